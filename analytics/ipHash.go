@@ -0,0 +1,43 @@
+package analytics
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+)
+
+// ipHashLength is the number of hexadecimal characters that are kept of an IP hash, enough to tell visitors apart
+// while the truncation and the salt make it impractical to find the IP back
+const ipHashLength = 16
+
+// IPHashSaltFromEnv returns the IP_HASH_SALT environment variable, when it isn't set a random salt is generated so the
+// IP hashes only stay comparable until the application restarts
+func IPHashSaltFromEnv() []byte {
+	if salt := os.Getenv("IP_HASH_SALT"); salt != "" {
+		return []byte(salt)
+	}
+
+	fmt.Println("IP_HASH_SALT isn't set, using a random salt so unique visitors are only counted until the next restart")
+	salt := make([]byte, 32)
+	if _, err := rand.Read(salt); err != nil {
+		panic(fmt.Sprintf("Failed to generate an IP hash salt:\n%v", err))
+	}
+
+	return salt
+}
+
+// HashIP returns a truncated HMAC-SHA256 hash of the IP address, so visits from the same IP can be counted without
+// storing the IP itself
+func HashIP(ip string, salt []byte) string {
+	if ip == "" {
+		return ""
+	}
+
+	mac := hmac.New(sha256.New, salt)
+	mac.Write([]byte(ip))
+
+	return hex.EncodeToString(mac.Sum(nil))[:ipHashLength]
+}
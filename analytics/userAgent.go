@@ -0,0 +1,95 @@
+package analytics
+
+import "strings"
+
+// UserAgent contains what a visit's User-Agent header says about the visitor
+type UserAgent struct {
+	Browser     string
+	OS          string
+	DeviceClass string
+}
+
+// browserTokens are checked in order because most browsers also mention the browsers they're based on
+var browserTokens = []struct {
+	token   string
+	browser string
+}{
+	{"edg", "Edge"},
+	{"opr/", "Opera"},
+	{"opera", "Opera"},
+	{"samsungbrowser", "Samsung Internet"},
+	{"crios", "Chrome"},
+	{"chrome", "Chrome"},
+	{"chromium", "Chromium"},
+	{"fxios", "Firefox"},
+	{"firefox", "Firefox"},
+	{"msie", "Internet Explorer"},
+	{"trident/", "Internet Explorer"},
+	{"safari", "Safari"},
+}
+
+// osTokens are checked in order because Android also mentions Linux and iOS also mentions Mac OS X
+var osTokens = []struct {
+	token string
+	os    string
+}{
+	{"windows", "Windows"},
+	{"android", "Android"},
+	{"iphone", "iOS"},
+	{"ipad", "iOS"},
+	{"ipod", "iOS"},
+	{"cros ", "Chrome OS"},
+	{"mac os x", "macOS"},
+	{"macintosh", "macOS"},
+	{"linux", "Linux"},
+}
+
+var botTokens = []string{"bot", "crawler", "spider", "slurp", "curl", "wget", "python-requests", "go-http-client"}
+
+// ParseUserAgent returns the browser, operating system and device class (desktop, mobile, tablet, bot or unknown) of
+// a User-Agent header, it only recognizes the common ones and falls back to "Other"
+func ParseUserAgent(userAgent string) UserAgent {
+	if userAgent == "" {
+		return UserAgent{Browser: "Other", OS: "Other", DeviceClass: "unknown"}
+	}
+
+	lowerUserAgent := strings.ToLower(userAgent)
+	parsed := UserAgent{Browser: "Other", OS: "Other"}
+
+	for _, browserToken := range browserTokens {
+		if strings.Contains(lowerUserAgent, browserToken.token) {
+			parsed.Browser = browserToken.browser
+			break
+		}
+	}
+
+	for _, osToken := range osTokens {
+		if strings.Contains(lowerUserAgent, osToken.token) {
+			parsed.OS = osToken.os
+			break
+		}
+	}
+
+	parsed.DeviceClass = deviceClass(lowerUserAgent)
+
+	return parsed
+}
+
+func deviceClass(lowerUserAgent string) string {
+	for _, botToken := range botTokens {
+		if strings.Contains(lowerUserAgent, botToken) {
+			return "bot"
+		}
+	}
+
+	switch {
+	case strings.Contains(lowerUserAgent, "ipad") || strings.Contains(lowerUserAgent, "tablet") ||
+		(strings.Contains(lowerUserAgent, "android") && !strings.Contains(lowerUserAgent, "mobile")):
+		return "tablet"
+	case strings.Contains(lowerUserAgent, "mobi") || strings.Contains(lowerUserAgent, "iphone") ||
+		strings.Contains(lowerUserAgent, "ipod"):
+		return "mobile"
+	default:
+		return "desktop"
+	}
+}
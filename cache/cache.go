@@ -0,0 +1,13 @@
+package cache
+
+import "time"
+
+// Cache stores values by key until their TTL runs out, the implementations are safe for concurrent use
+type Cache interface {
+	// Get returns the value of the key, ok is false when the key isn't in the cache or expired
+	Get(key string) (value []byte, ok bool, err error)
+	// Set adds or replaces the value of the key, it expires after the TTL
+	Set(key string, value []byte, ttl time.Duration) error
+	// Delete removes the key from the cache
+	Delete(key string) error
+}
@@ -0,0 +1,73 @@
+package cache
+
+import "time"
+
+// Layered is a Cache that keeps the values of a shared Redis in a local LRU as well. Deleted keys are published on a
+// Redis channel so every replica removes them from its LRU.
+type Layered struct {
+	local  *LRU
+	remote *Redis
+	// localTTL is how long a value that was found in Redis stays in the LRU
+	localTTL time.Duration
+	channel  string
+}
+
+// NewLayered returns a Layered that listens on the channel for deleted keys
+func NewLayered(local *LRU, remote *Redis, localTTL time.Duration, channel string) *Layered {
+	l := &Layered{
+		local:    local,
+		remote:   remote,
+		localTTL: localTTL,
+		channel:  channel,
+	}
+
+	remote.Subscribe(channel, func(key string) {
+		l.local.Delete(key)
+	}, l.local.Clear)
+
+	return l
+}
+
+// Get returns the value of the key from the LRU or from Redis
+func (l *Layered) Get(key string) ([]byte, bool, error) {
+	if value, ok, _ := l.local.Get(key); ok {
+		return value, true, nil
+	}
+
+	value, ok, err := l.remote.Get(key)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+
+	l.local.Set(key, value, l.localTTL)
+
+	return value, true, nil
+}
+
+// Set adds or replaces the value of the key in the LRU and Redis
+func (l *Layered) Set(key string, value []byte, ttl time.Duration) error {
+	localTTL := ttl
+	if localTTL > l.localTTL {
+		localTTL = l.localTTL
+	}
+	l.local.Set(key, value, localTTL)
+
+	return l.remote.Set(key, value, ttl)
+}
+
+// Delete removes the key from Redis and the LRUs of every replica
+func (l *Layered) Delete(key string) error {
+	l.local.Delete(key)
+
+	err := l.remote.Delete(key)
+	if err != nil {
+		return err
+	}
+
+	return l.remote.Publish(l.channel, key)
+}
+
+// Close stops listening for deleted keys and closes the Redis connections
+func (l *Layered) Close() error {
+	return l.remote.Close()
+}
@@ -0,0 +1,97 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLRUEvictsTheLeastRecentlyUsedEntry(t *testing.T) {
+	c := NewLRU(2)
+	c.Set("a", []byte("1"), time.Minute)
+	c.Set("b", []byte("2"), time.Minute)
+
+	// Getting a makes b the least recently used entry
+	if value, ok, _ := c.Get("a"); !ok || string(value) != "1" {
+		t.Fatalf("Get(a) = %q, %v, want 1", value, ok)
+	}
+	c.Set("c", []byte("3"), time.Minute)
+
+	if _, ok, _ := c.Get("b"); ok {
+		t.Error("b wasn't evicted")
+	}
+	for key, want := range map[string]string{"a": "1", "c": "3"} {
+		if value, ok, _ := c.Get(key); !ok || string(value) != want {
+			t.Errorf("Get(%s) = %q, %v, want %s", key, value, ok, want)
+		}
+	}
+	if c.Len() != 2 {
+		t.Errorf("Len = %d, want 2", c.Len())
+	}
+
+	// Replacing a value doesn't evict anything
+	c.Set("a", []byte("4"), time.Minute)
+	if value, ok, _ := c.Get("a"); !ok || string(value) != "4" {
+		t.Errorf("Get(a) = %q, %v after replacing it, want 4", value, ok)
+	}
+	if _, ok, _ := c.Get("c"); !ok {
+		t.Error("c was evicted when a was replaced")
+	}
+}
+
+func TestLRUExpiresEntries(t *testing.T) {
+	c := NewLRU(10)
+	c.Set("short", []byte("1"), 20*time.Millisecond)
+	c.Set("long", []byte("2"), time.Minute)
+
+	time.Sleep(50 * time.Millisecond)
+
+	if _, ok, _ := c.Get("short"); ok {
+		t.Error("short didn't expire after its TTL")
+	}
+	if _, ok, _ := c.Get("long"); !ok {
+		t.Error("long expired before its TTL")
+	}
+	if c.Len() != 1 {
+		t.Errorf("Len = %d, want the expired entry to be removed when it was read", c.Len())
+	}
+
+	// Setting the value again starts a new TTL
+	c.Set("short", []byte("3"), 20*time.Millisecond)
+	c.Set("short", []byte("3"), time.Minute)
+	time.Sleep(50 * time.Millisecond)
+	if _, ok, _ := c.Get("short"); !ok {
+		t.Error("short expired with the TTL it was replaced")
+	}
+}
+
+func TestLRUDeleteAndClear(t *testing.T) {
+	c := NewLRU(10)
+	c.Set("a", []byte("1"), time.Minute)
+	c.Set("b", []byte("2"), time.Minute)
+
+	c.Delete("a")
+	c.Delete("missing")
+	if _, ok, _ := c.Get("a"); ok {
+		t.Error("a wasn't deleted")
+	}
+	if c.Len() != 1 {
+		t.Errorf("Len = %d after Delete, want 1", c.Len())
+	}
+
+	c.Clear()
+	if _, ok, _ := c.Get("b"); ok {
+		t.Error("b wasn't cleared")
+	}
+	if c.Len() != 0 {
+		t.Errorf("Len = %d after Clear, want 0", c.Len())
+	}
+}
+
+func TestLRUWithoutCapacityStoresNothing(t *testing.T) {
+	c := NewLRU(0)
+	c.Set("a", []byte("1"), time.Minute)
+
+	if _, ok, _ := c.Get("a"); ok {
+		t.Error("An LRU without capacity stored a")
+	}
+}
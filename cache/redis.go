@@ -0,0 +1,405 @@
+package cache
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultRedisPort  = "6379"
+	redisTimeout      = time.Second
+	redisMaxIdleConns = 10
+	// redisReconnectDelay is how long a lost subscription waits before it reconnects
+	redisReconnectDelay = time.Second
+	// redisFailureBackoff is how long Get and Set skip the server after it failed, so an outage doesn't add the
+	// timeouts to every lookup
+	redisFailureBackoff = 5 * time.Second
+)
+
+// ErrRedisUnavailable is returned by Get and Set while they skip the server after it failed
+var ErrRedisUnavailable = errors.New("redis: skipped because the server failed recently")
+
+// RedisError is an error reply of the Redis server, the connection can still be used after it
+type RedisError string
+
+func (err RedisError) Error() string {
+	return "redis: " + string(err)
+}
+
+// Redis is a Cache that stores its values in a Redis server, or any other server that speaks the Redis protocol
+// (RESP), so every replica of the application shares it
+type Redis struct {
+	address  string
+	useTLS   bool
+	username string
+	password string
+	database int
+
+	idle   chan *redisConn
+	closed chan struct{}
+
+	mu sync.Mutex
+	// subscriptions are the connections that wait for published messages, they're closed to stop waiting
+	subscriptions map[*redisConn]bool
+	// failedAt is when a command last failed to reach the server
+	failedAt time.Time
+}
+
+type redisConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	writer *bufio.Writer
+}
+
+// NewRedis connects to the Redis server of a URL like redis://:password@localhost:6379/0, rediss:// uses TLS
+func NewRedis(rawURL string) (*Redis, error) {
+	parsedURL, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if parsedURL.Scheme != "redis" && parsedURL.Scheme != "rediss" {
+		return nil, fmt.Errorf("unsupported Redis URL scheme %q, use redis:// or rediss://", parsedURL.Scheme)
+	}
+
+	r := &Redis{
+		address:       parsedURL.Host,
+		useTLS:        parsedURL.Scheme == "rediss",
+		idle:          make(chan *redisConn, redisMaxIdleConns),
+		closed:        make(chan struct{}),
+		subscriptions: make(map[*redisConn]bool),
+	}
+
+	if parsedURL.Port() == "" {
+		r.address = net.JoinHostPort(parsedURL.Hostname(), defaultRedisPort)
+	}
+
+	if parsedURL.User != nil {
+		r.username = parsedURL.User.Username()
+		r.password, _ = parsedURL.User.Password()
+	}
+
+	if database := strings.TrimPrefix(parsedURL.Path, "/"); database != "" {
+		r.database, err = strconv.Atoi(database)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Redis database %q", database)
+		}
+	}
+
+	// Fail right away when the server can't be reached
+	_, err = r.do("PING")
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Get returns the value of the key, ok is false when the key doesn't exist or expired
+func (r *Redis) Get(key string) ([]byte, bool, error) {
+	if r.backingOff() {
+		return nil, false, ErrRedisUnavailable
+	}
+
+	reply, err := r.do("GET", key)
+	if err != nil || reply == nil {
+		return nil, false, err
+	}
+
+	value, ok := reply.([]byte)
+	if !ok {
+		return nil, false, fmt.Errorf("redis: unexpected GET reply %v", reply)
+	}
+
+	return value, true, nil
+}
+
+// Set adds or replaces the value of the key, Redis removes it after the TTL
+func (r *Redis) Set(key string, value []byte, ttl time.Duration) error {
+	if r.backingOff() {
+		return ErrRedisUnavailable
+	}
+
+	milliseconds := ttl.Milliseconds()
+	if milliseconds < 1 {
+		milliseconds = 1
+	}
+
+	_, err := r.do("SET", key, string(value), "PX", strconv.FormatInt(milliseconds, 10))
+	return err
+}
+
+// Delete removes the key, unlike Get and Set it always tries the server so no change is missed
+func (r *Redis) Delete(key string) error {
+	_, err := r.do("DEL", key)
+	return err
+}
+
+// Publish sends the message to the subscribers of the channel
+func (r *Redis) Publish(channel string, message string) error {
+	_, err := r.do("PUBLISH", channel, message)
+	return err
+}
+
+// Subscribe calls onMessage in the background with every message that is published on the channel until the Redis is
+// closed. A lost connection is reconnected and onResubscribe is called because the messages in between were missed.
+func (r *Redis) Subscribe(channel string, onMessage func(message string), onResubscribe func()) {
+	go func() {
+		subscribed := false
+		for {
+			c, err := r.dial()
+			if err == nil {
+				_, err = c.do(redisTimeout, "SUBSCRIBE", channel)
+				if err == nil {
+					if subscribed {
+						onResubscribe()
+					}
+					subscribed = true
+
+					r.receive(c, onMessage)
+				}
+
+				c.conn.Close()
+			}
+
+			select {
+			case <-r.closed:
+				return
+			case <-time.After(redisReconnectDelay):
+			}
+		}
+	}()
+}
+
+// receive waits for the published messages until the connection is lost or closed
+func (r *Redis) receive(c *redisConn, onMessage func(message string)) {
+	r.mu.Lock()
+	select {
+	case <-r.closed:
+		r.mu.Unlock()
+		return
+	default:
+		r.subscriptions[c] = true
+	}
+	r.mu.Unlock()
+
+	defer func() {
+		r.mu.Lock()
+		delete(r.subscriptions, c)
+		r.mu.Unlock()
+	}()
+
+	// Messages can take any amount of time to arrive
+	c.conn.SetDeadline(time.Time{})
+
+	for {
+		reply, err := c.readReply()
+		if err != nil {
+			return
+		}
+
+		// A message is the array ["message", channel, message]
+		values, ok := reply.([]interface{})
+		if !ok || len(values) != 3 {
+			continue
+		}
+		kind, _ := values[0].([]byte)
+		message, _ := values[2].([]byte)
+		if string(kind) == "message" {
+			onMessage(string(message))
+		}
+	}
+}
+
+// Close stops the subscriptions and closes the connections
+func (r *Redis) Close() error {
+	r.mu.Lock()
+	close(r.closed)
+	for c := range r.subscriptions {
+		c.conn.Close()
+	}
+	r.mu.Unlock()
+
+	for {
+		select {
+		case c := <-r.idle:
+			c.conn.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+// backingOff is true during the redisFailureBackoff after a command failed to reach the server
+func (r *Redis) backingOff() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return time.Since(r.failedAt) < redisFailureBackoff
+}
+
+// do sends the command on an idle connection or a new one when none is idle
+func (r *Redis) do(args ...string) (interface{}, error) {
+	var c *redisConn
+	select {
+	case c = <-r.idle:
+	default:
+		var err error
+		c, err = r.dial()
+		if err != nil {
+			r.failed()
+			return nil, err
+		}
+	}
+
+	reply, err := c.do(redisTimeout, args...)
+	var redisErr RedisError
+	if err != nil && !errors.As(err, &redisErr) {
+		// The connection could be halfway through a reply
+		c.conn.Close()
+		r.failed()
+		return nil, err
+	}
+
+	select {
+	case r.idle <- c:
+	default:
+		c.conn.Close()
+	}
+
+	return reply, err
+}
+
+// failed makes Get and Set back off
+func (r *Redis) failed() {
+	r.mu.Lock()
+	r.failedAt = time.Now()
+	r.mu.Unlock()
+}
+
+// dial opens a connection that is authenticated and uses the database of the URL
+func (r *Redis) dial() (*redisConn, error) {
+	dialer := &net.Dialer{Timeout: redisTimeout}
+
+	var conn net.Conn
+	var err error
+	if r.useTLS {
+		host, _, _ := net.SplitHostPort(r.address)
+		conn, err = tls.DialWithDialer(dialer, "tcp", r.address, &tls.Config{ServerName: host})
+	} else {
+		conn, err = dialer.Dial("tcp", r.address)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c := &redisConn{
+		conn:   conn,
+		reader: bufio.NewReader(conn),
+		writer: bufio.NewWriter(conn),
+	}
+
+	if r.password != "" {
+		if r.username != "" {
+			_, err = c.do(redisTimeout, "AUTH", r.username, r.password)
+		} else {
+			_, err = c.do(redisTimeout, "AUTH", r.password)
+		}
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	if r.database != 0 {
+		_, err = c.do(redisTimeout, "SELECT", strconv.Itoa(r.database))
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// do sends the command as an array of bulk strings and reads the reply
+func (c *redisConn) do(timeout time.Duration, args ...string) (interface{}, error) {
+	err := c.conn.SetDeadline(time.Now().Add(timeout))
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Fprintf(c.writer, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(c.writer, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+
+	err = c.writer.Flush()
+	if err != nil {
+		return nil, err
+	}
+
+	return c.readReply()
+}
+
+// readReply reads a simple string, error, integer, bulk string ([]byte) or array ([]interface{}) reply, a null bulk
+// string or array is nil
+func (c *redisConn) readReply() (interface{}, error) {
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("redis: empty reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, RedisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		length, err := strconv.Atoi(line[1:])
+		if err != nil || length < 0 {
+			return nil, err
+		}
+
+		// The bulk string ends with \r\n as well
+		value := make([]byte, length+2)
+		_, err = io.ReadFull(c.reader, value)
+		if err != nil {
+			return nil, err
+		}
+
+		return value[:length], nil
+	case '*':
+		length, err := strconv.Atoi(line[1:])
+		if err != nil || length < 0 {
+			return nil, err
+		}
+
+		values := make([]interface{}, length)
+		for i := range values {
+			values[i], err = c.readReply()
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		return values, nil
+	default:
+		return nil, fmt.Errorf("redis: unknown reply type %q", line[0])
+	}
+}
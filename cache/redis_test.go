@@ -0,0 +1,436 @@
+package cache
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedis is a Redis server on a random local port that supports the commands the Redis Cache sends
+type fakeRedis struct {
+	listener net.Listener
+	password string
+
+	mu        sync.Mutex
+	databases map[int]map[string]fakeRedisValue
+	// subscribers are the connections that subscribed to a channel, by channel
+	subscribers map[string]map[net.Conn]bool
+	conns       map[net.Conn]bool
+}
+
+type fakeRedisValue struct {
+	value     string
+	expiresAt time.Time
+}
+
+// newFakeRedis starts a fakeRedis that requires the password when it isn't empty
+func newFakeRedis(t *testing.T, password string) *fakeRedis {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+
+	f := &fakeRedis{
+		listener:    listener,
+		password:    password,
+		databases:   make(map[int]map[string]fakeRedisValue),
+		subscribers: make(map[string]map[net.Conn]bool),
+		conns:       make(map[net.Conn]bool),
+	}
+	t.Cleanup(f.close)
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			f.mu.Lock()
+			f.conns[conn] = true
+			f.mu.Unlock()
+
+			go f.serve(conn)
+		}
+	}()
+
+	return f
+}
+
+// url returns the URL of the fakeRedis with the password and the database
+func (f *fakeRedis) url(password string, database int) string {
+	return fmt.Sprintf("redis://:%s@%s/%d", password, f.listener.Addr(), database)
+}
+
+func (f *fakeRedis) close() {
+	f.listener.Close()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for conn := range f.conns {
+		conn.Close()
+	}
+}
+
+// subscriberCount returns the number of connections that subscribed to the channel
+func (f *fakeRedis) subscriberCount(channel string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return len(f.subscribers[channel])
+}
+
+// dropSubscribers closes the connections that subscribed to a channel like a restarting server would
+func (f *fakeRedis) dropSubscribers() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for channel, conns := range f.subscribers {
+		for conn := range conns {
+			conn.Close()
+		}
+		delete(f.subscribers, channel)
+	}
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer func() {
+		f.mu.Lock()
+		delete(f.conns, conn)
+		for _, conns := range f.subscribers {
+			delete(conns, conn)
+		}
+		f.mu.Unlock()
+
+		conn.Close()
+	}()
+
+	reader := bufio.NewReader(conn)
+	authenticated := f.password == ""
+	database := 0
+	for {
+		args, err := readFakeRedisCommand(reader)
+		if err != nil {
+			return
+		}
+
+		f.mu.Lock()
+		command := strings.ToUpper(args[0])
+		var reply string
+		switch {
+		case command == "AUTH":
+			authenticated = args[len(args)-1] == f.password
+			reply = "+OK\r\n"
+			if !authenticated {
+				reply = "-WRONGPASS invalid username-password pair\r\n"
+			}
+		case !authenticated:
+			reply = "-NOAUTH Authentication required.\r\n"
+		case command == "PING":
+			reply = "+PONG\r\n"
+		case command == "SELECT":
+			database, _ = strconv.Atoi(args[1])
+			reply = "+OK\r\n"
+		case command == "GET":
+			value, ok := f.databases[database][args[1]]
+			reply = "$-1\r\n"
+			if ok && time.Now().Before(value.expiresAt) {
+				reply = fakeRedisBulkString(value.value)
+			}
+		case command == "SET" && len(args) == 5 && strings.ToUpper(args[3]) == "PX":
+			milliseconds, _ := strconv.Atoi(args[4])
+			if f.databases[database] == nil {
+				f.databases[database] = make(map[string]fakeRedisValue)
+			}
+			f.databases[database][args[1]] = fakeRedisValue{
+				value:     args[2],
+				expiresAt: time.Now().Add(time.Duration(milliseconds) * time.Millisecond),
+			}
+			reply = "+OK\r\n"
+		case command == "DEL":
+			deleted := 0
+			for _, key := range args[1:] {
+				if _, ok := f.databases[database][key]; ok {
+					delete(f.databases[database], key)
+					deleted++
+				}
+			}
+			reply = fmt.Sprintf(":%d\r\n", deleted)
+		case command == "PUBLISH":
+			for subscriber := range f.subscribers[args[1]] {
+				fmt.Fprintf(subscriber, "*3\r\n%s%s%s", fakeRedisBulkString("message"), fakeRedisBulkString(args[1]),
+					fakeRedisBulkString(args[2]))
+			}
+			reply = fmt.Sprintf(":%d\r\n", len(f.subscribers[args[1]]))
+		case command == "SUBSCRIBE":
+			if f.subscribers[args[1]] == nil {
+				f.subscribers[args[1]] = make(map[net.Conn]bool)
+			}
+			f.subscribers[args[1]][conn] = true
+			reply = "*3\r\n" + fakeRedisBulkString("subscribe") + fakeRedisBulkString(args[1]) + ":1\r\n"
+		default:
+			reply = "-ERR unknown command '" + args[0] + "'\r\n"
+		}
+
+		// PUBLISH writes to the subscribers with the lock held as well, so the replies don't interleave
+		io.WriteString(conn, reply)
+		f.mu.Unlock()
+	}
+}
+
+// readFakeRedisCommand reads a command that was sent as an array of bulk strings
+func readFakeRedisCommand(reader *bufio.Reader) ([]string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	count, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "*")))
+	if err != nil || count < 1 {
+		return nil, fmt.Errorf("invalid command %q", line)
+	}
+
+	args := make([]string, count)
+	for i := range args {
+		line, err = reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+
+		length, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "$")))
+		if err != nil {
+			return nil, fmt.Errorf("invalid bulk string %q", line)
+		}
+
+		value := make([]byte, length+2)
+		_, err = io.ReadFull(reader, value)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = string(value[:length])
+	}
+
+	return args, nil
+}
+
+func fakeRedisBulkString(value string) string {
+	return fmt.Sprintf("$%d\r\n%s\r\n", len(value), value)
+}
+
+// newTestRedis connects to the fakeRedis and closes the Redis when the test ends
+func newTestRedis(t *testing.T, rawURL string) *Redis {
+	t.Helper()
+
+	r, err := NewRedis(rawURL)
+	if err != nil {
+		t.Fatalf("NewRedis(%s) returned %v", rawURL, err)
+	}
+	t.Cleanup(func() {
+		r.Close()
+	})
+
+	return r
+}
+
+// waitFor waits until the condition is true, the subscriptions receive the messages in the background
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(3 * redisReconnectDelay)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting until %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRedisAuthenticatesAndSelectsTheDatabase(t *testing.T) {
+	f := newFakeRedis(t, "secret")
+
+	_, err := NewRedis(f.url("wrong", 0))
+	if _, ok := err.(RedisError); !ok {
+		t.Fatalf("NewRedis with the wrong password returned %v, want a RedisError", err)
+	}
+
+	database2 := newTestRedis(t, f.url("secret", 2))
+	database0 := newTestRedis(t, f.url("secret", 0))
+
+	err = database2.Set("key", []byte("value"), time.Minute)
+	if err != nil {
+		t.Fatalf("Set returned %v", err)
+	}
+
+	if value, ok, err := database2.Get("key"); !ok || err != nil || string(value) != "value" {
+		t.Errorf("Get = %q, %v, %v on database 2, want value", value, ok, err)
+	}
+	if value, ok, err := database0.Get("key"); ok || err != nil {
+		t.Errorf("Get = %q, %v, %v on database 0, want the key of database 2 to be missing", value, ok, err)
+	}
+}
+
+func TestRedisGetSetDelete(t *testing.T) {
+	f := newFakeRedis(t, "")
+	r := newTestRedis(t, f.url("", 0))
+
+	if _, ok, err := r.Get("missing"); ok || err != nil {
+		t.Errorf("Get(missing) = %v, %v, want it to be missing", ok, err)
+	}
+
+	err := r.Set("key", []byte("value\r\nwith a line break"), time.Minute)
+	if err != nil {
+		t.Fatalf("Set returned %v", err)
+	}
+	if value, ok, err := r.Get("key"); !ok || err != nil || string(value) != "value\r\nwith a line break" {
+		t.Errorf("Get(key) = %q, %v, %v, want the value that was set", value, ok, err)
+	}
+
+	err = r.Delete("key")
+	if err != nil {
+		t.Fatalf("Delete returned %v", err)
+	}
+	if _, ok, err := r.Get("key"); ok || err != nil {
+		t.Errorf("Get(key) = %v, %v after Delete, want it to be missing", ok, err)
+	}
+
+	err = r.Set("short", []byte("value"), 20*time.Millisecond)
+	if err != nil {
+		t.Fatalf("Set returned %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if _, ok, err := r.Get("short"); ok || err != nil {
+		t.Errorf("Get(short) = %v, %v after its TTL, want it to be missing", ok, err)
+	}
+
+	// The connection can still be used after an error reply
+	_, err = r.do("UNKNOWN")
+	if _, ok := err.(RedisError); !ok {
+		t.Errorf("An unknown command returned %v, want a RedisError", err)
+	}
+	if err = r.Set("key", []byte("value"), time.Minute); err != nil {
+		t.Errorf("Set returned %v after an error reply", err)
+	}
+}
+
+func TestRedisBacksOffAfterAFailure(t *testing.T) {
+	// A server that accepts connections but never replies makes every command wait for the timeout
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	r := &Redis{address: listener.Addr().String(), idle: make(chan *redisConn, redisMaxIdleConns), closed: make(chan struct{})}
+
+	if _, _, err := r.Get("key"); err == nil || err == ErrRedisUnavailable {
+		t.Fatalf("Get returned %v from a server that doesn't reply, want a timeout", err)
+	}
+
+	// Get and Set skip the server, Delete still tries it so no change is missed
+	start := time.Now()
+	if _, _, err := r.Get("key"); err != ErrRedisUnavailable {
+		t.Errorf("Get returned %v after the failure, want ErrRedisUnavailable", err)
+	}
+	if err := r.Set("key", []byte("value"), time.Minute); err != ErrRedisUnavailable {
+		t.Errorf("Set returned %v after the failure, want ErrRedisUnavailable", err)
+	}
+	if elapsed := time.Since(start); elapsed > redisTimeout/2 {
+		t.Errorf("Get and Set took %v after the failure, want them to return right away", elapsed)
+	}
+	if err := r.Delete("key"); err == nil || err == ErrRedisUnavailable {
+		t.Errorf("Delete returned %v after the failure, want it to try the server", err)
+	}
+
+	// After the backoff the server is tried again
+	r.failedAt = time.Now().Add(-redisFailureBackoff)
+	if _, _, err := r.Get("key"); err == ErrRedisUnavailable {
+		t.Errorf("Get returned %v after the backoff, want it to try the server", err)
+	}
+}
+
+// newTestLayered returns a Layered on the fakeRedis that subscribed to its channel
+func newTestLayered(t *testing.T, f *fakeRedis) *Layered {
+	t.Helper()
+
+	subscribers := f.subscriberCount("deleted")
+	l := NewLayered(NewLRU(10), newTestRedis(t, f.url("", 0)), time.Minute, "deleted")
+	waitFor(t, "the Layered subscribed", func() bool {
+		return f.subscriberCount("deleted") == subscribers+1
+	})
+
+	return l
+}
+
+func TestLayeredDeleteEvictsTheKeyFromEveryReplica(t *testing.T) {
+	f := newFakeRedis(t, "")
+	replica1 := newTestLayered(t, f)
+	replica2 := newTestLayered(t, f)
+
+	err := replica1.Set("key", []byte("value"), time.Minute)
+	if err != nil {
+		t.Fatalf("Set returned %v", err)
+	}
+
+	// The second replica keeps the value it got from Redis in its LRU
+	if value, ok, err := replica2.Get("key"); !ok || err != nil || string(value) != "value" {
+		t.Fatalf("Get = %q, %v, %v on the second replica, want value", value, ok, err)
+	}
+	if _, ok, _ := replica2.local.Get("key"); !ok {
+		t.Fatal("The second replica didn't keep the value in its LRU")
+	}
+
+	err = replica1.Delete("key")
+	if err != nil {
+		t.Fatalf("Delete returned %v", err)
+	}
+
+	waitFor(t, "the second replica evicted the key from its LRU", func() bool {
+		_, ok, _ := replica2.local.Get("key")
+		return !ok
+	})
+	if _, ok, err := replica2.Get("key"); ok || err != nil {
+		t.Errorf("Get = %v, %v on the second replica after Delete, want it to be missing", ok, err)
+	}
+}
+
+func TestLayeredClearsTheLRUAfterResubscribing(t *testing.T) {
+	f := newFakeRedis(t, "")
+	l := newTestLayered(t, f)
+
+	err := l.Set("key", []byte("value"), time.Minute)
+	if err != nil {
+		t.Fatalf("Set returned %v", err)
+	}
+
+	// The keys that were deleted while the subscription was lost could still be in the LRU
+	f.dropSubscribers()
+
+	waitFor(t, "the Layered subscribed again", func() bool {
+		return f.subscriberCount("deleted") == 1
+	})
+	waitFor(t, "the LRU was cleared", func() bool {
+		return l.local.Len() == 0
+	})
+
+	// The value is still in Redis
+	if value, ok, err := l.Get("key"); !ok || err != nil || string(value) != "value" {
+		t.Errorf("Get = %q, %v, %v after resubscribing, want value", value, ok, err)
+	}
+}
@@ -7,8 +7,9 @@ require (
 	github.com/gin-contrib/cors v1.3.1
 	github.com/gin-gonic/gin v1.6.3
 	github.com/go-sql-driver/mysql v1.5.0
-	github.com/itchyny/base58-go v0.1.0
 	github.com/joho/godotenv v1.3.0
+	github.com/lib/pq v1.9.0
+	github.com/mattn/go-sqlite3 v1.14.7 // bundles SQLite 3.35, the first version with ALTER TABLE DROP COLUMN
 	github.com/satori/go.uuid v1.2.0
 	golang.org/x/crypto v0.0.0-20201221181555-eec23a3978ad
 	xorm.io/xorm v1.0.6
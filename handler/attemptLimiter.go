@@ -0,0 +1,66 @@
+package handler
+
+import (
+	"sync"
+	"time"
+)
+
+// attemptLimiter keeps track of failed attempts per key in memory and blocks a key after too many failures
+type attemptLimiter struct {
+	mutex       sync.Mutex
+	maxAttempts int
+	window      time.Duration
+	failures    map[string]attemptWindow
+}
+
+type attemptWindow struct {
+	count   int
+	resetAt time.Time
+}
+
+// newAttemptLimiter returns an attemptLimiter that allows maxAttempts failed attempts per key within the window
+func newAttemptLimiter(maxAttempts int, window time.Duration) *attemptLimiter {
+	return &attemptLimiter{
+		maxAttempts: maxAttempts,
+		window:      window,
+		failures:    make(map[string]attemptWindow),
+	}
+}
+
+// allowed checks whether the key has attempts left
+func (l *attemptLimiter) allowed(key string) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	failures, ok := l.failures[key]
+	return !ok || time.Now().After(failures.resetAt) || failures.count < l.maxAttempts
+}
+
+// fail counts a failed attempt for the key
+func (l *attemptLimiter) fail(key string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+	// Forget the keys whose window passed so the map doesn't keep growing
+	for otherKey, failures := range l.failures {
+		if now.After(failures.resetAt) {
+			delete(l.failures, otherKey)
+		}
+	}
+
+	failures, ok := l.failures[key]
+	if !ok {
+		failures.resetAt = now.Add(l.window)
+	}
+	failures.count++
+	l.failures[key] = failures
+}
+
+// reset forgets the failed attempts of the key
+func (l *attemptLimiter) reset(key string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	delete(l.failures, key)
+}
@@ -0,0 +1,26 @@
+package handler
+
+import "testing"
+
+func TestAttemptLimiterBlocksAfterMaxAttempts(t *testing.T) {
+	limiter := newAttemptLimiter(2, passwordAttemptWindow)
+
+	for i := 0; i < 2; i++ {
+		if !limiter.allowed("key") {
+			t.Fatalf("attempt %d was blocked, want it allowed", i+1)
+		}
+		limiter.fail("key")
+	}
+
+	if limiter.allowed("key") {
+		t.Error("the key is allowed after 2 failures, want it blocked")
+	}
+	if !limiter.allowed("other key") {
+		t.Error("another key is blocked by the failures of the key")
+	}
+
+	limiter.reset("key")
+	if !limiter.allowed("key") {
+		t.Error("the key is blocked after a reset")
+	}
+}
@@ -1,24 +1,106 @@
 package handler
 
 import (
+	"errors"
 	"net/http"
 	"os"
 	"strings"
+	"time"
 
+	"github.com/devlaminckduncan/url-shortener/analytics"
 	"github.com/devlaminckduncan/url-shortener/shortener"
 	"github.com/devlaminckduncan/url-shortener/store"
 	"github.com/dgrijalva/jwt-go"
 	"github.com/gin-gonic/gin"
+	uuid "github.com/satori/go.uuid"
 )
 
+// Handler contains the route handlers with the Store and the short URL Generator they use
+type Handler struct {
+	store     store.Store
+	generator *shortener.Generator
+	// passwordAttempts throttles the wrong passwords per visitor IP and short URL, a cap per short URL would let anyone
+	// lock the visitors out, so the slow password hashes keep guessing from many IPs expensive instead
+	passwordAttempts *attemptLimiter
+	// defaultRedirectType is used for short URLs without a redirect type
+	defaultRedirectType int
+	// ipHashSalt salts the hashes of the visitor IPs
+	ipHashSalt []byte
+}
+
+const (
+	maxPasswordAttempts   = 5
+	passwordAttemptWindow = 15 * time.Minute
+)
+
+// NewHandler returns a Handler that uses the given Store and Generator, the DEFAULT_REDIRECT_TYPE environment variable
+// sets the redirect type of short URLs that don't have one and IP_HASH_SALT salts the hashes of the visitor IPs
+func NewHandler(urlStore store.Store, generator *shortener.Generator) *Handler {
+	return &Handler{
+		store:               urlStore,
+		generator:           generator,
+		passwordAttempts:    newAttemptLimiter(maxPasswordAttempts, passwordAttemptWindow),
+		defaultRedirectType: defaultRedirectTypeFromEnv(),
+		ipHashSalt:          analytics.IPHashSaltFromEnv(),
+	}
+}
+
 type urlCreationRequest struct {
 	Name    string `json:"name" binding:"required"`
 	LongURL string `json:"longURL" binding:"required"`
-	UserID  string `json:"userID" binding:"required"`
+	// UserID is optional because the creator is the user of the token, when it's given it has to match the token
+	UserID string `json:"userID"`
+	Alias  string `json:"alias"`
+	// OnDuplicate decides what happens when the user already shortened the long URL: "error" (default) returns
+	// DUPLICATE_URL, "reuse" returns the existing ShortenedURL and "new" creates another one with a different short URL
+	OnDuplicate string `json:"onDuplicate" binding:"omitempty,oneof=error reuse new"`
+	// ExpiresAt or TTL (in seconds) optionally sets when the short URL stops redirecting
+	ExpiresAt *time.Time `json:"expiresAt"`
+	TTL       int64      `json:"ttl" binding:"omitempty,min=1,max=3153600000"`
+	// MaxVisits optionally limits how many times the short URL redirects, 1 makes it a one-time link
+	MaxVisits int `json:"maxVisits" binding:"omitempty,min=1"`
+	// Password optionally protects the short URL, visitors have to enter it before they get redirected
+	Password string `json:"password"`
+	// ActiveFrom and ActiveUntil optionally schedule when the short URL redirects, for example for campaign links
+	ActiveFrom  *time.Time `json:"activeFrom"`
+	ActiveUntil *time.Time `json:"activeUntil"`
+	// RedirectType optionally overrides the server's default redirect status code: 301, 302, 307 or 308
+	RedirectType int `json:"redirectType" binding:"omitempty,oneof=301 302 307 308"`
 }
 
+// urlUpdateRequest only updates the given fields, changing the long URL keeps the short URL and its analytics
 type urlUpdateRequest struct {
-	Name string `json:"name" binding:"required"`
+	Name              string     `json:"name"`
+	LongURL           string     `json:"longURL"`
+	ExpiresAt         *time.Time `json:"expiresAt"`
+	TTL               int64      `json:"ttl" binding:"omitempty,min=1,max=3153600000"`
+	ClearExpiration   bool       `json:"clearExpiration"`
+	MaxVisits         int        `json:"maxVisits" binding:"omitempty,min=1"`
+	ClearMaxVisits    bool       `json:"clearMaxVisits"`
+	Password          string     `json:"password"`
+	ClearPassword     bool       `json:"clearPassword"`
+	ActiveFrom        *time.Time `json:"activeFrom"`
+	ActiveUntil       *time.Time `json:"activeUntil"`
+	ClearSchedule     bool       `json:"clearSchedule"`
+	RedirectType      int        `json:"redirectType" binding:"omitempty,oneof=301 302 307 308"`
+	ClearRedirectType bool       `json:"clearRedirectType"`
+}
+
+type statsRequest struct {
+	From     string `form:"from"`
+	To       string `form:"to"`
+	Interval string `form:"interval" binding:"omitempty,oneof=hour day week"`
+}
+
+const (
+	defaultStatsRange = 30 * 24 * time.Hour
+	maxStatsBuckets   = 1000
+)
+
+var statsIntervals = map[string]time.Duration{
+	"hour": time.Hour,
+	"day":  24 * time.Hour,
+	"week": 7 * 24 * time.Hour,
 }
 
 type userLoginRequest struct {
@@ -35,6 +117,25 @@ type userUpdateRequest struct {
 	Password  string `json:"password"`
 }
 
+type tokenRefreshRequest struct {
+	RefreshToken string `json:"refreshToken" binding:"required"`
+}
+
+// logoutRequest optionally has the refresh token of the login, without it the access token of the Authorization header
+// is used
+type logoutRequest struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+// apiKeyCreationRequest names a new API key and gives it at least one scope, ExpiresAt or TTL (in seconds) optionally
+// sets when the key stops working
+type apiKeyCreationRequest struct {
+	Name      string     `json:"name" binding:"required"`
+	Scopes    []string   `json:"scopes" binding:"required,min=1,dive,oneof=links:read links:write analytics:read"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+	TTL       int64      `json:"ttl" binding:"omitempty,min=1,max=3153600000"`
+}
+
 type tokenHeader struct {
 	Authorization string `header:"Authorization" binding:"required"`
 }
@@ -51,6 +152,74 @@ func compareUserIDWithToken(c *gin.Context, userID string, tokenUserID string) b
 	return true
 }
 
+// getExpiration returns when a short URL expires based on either an expiration date or a TTL in seconds
+func getExpiration(expiresAt *time.Time, ttl int64) (*time.Time, string) {
+	if expiresAt != nil && ttl != 0 {
+		return nil, "CONFLICTING_EXPIRATION"
+	}
+
+	now := time.Now()
+	if ttl != 0 {
+		expiration := now.Add(time.Duration(ttl) * time.Second)
+		return &expiration, "OK"
+	}
+	if expiresAt != nil && !expiresAt.After(now) {
+		return nil, "EXPIRATION_IN_PAST"
+	}
+
+	return expiresAt, "OK"
+}
+
+// checkSchedule checks that the activation window of a short URL isn't empty and doesn't end in the past
+func checkSchedule(activeFrom *time.Time, activeUntil *time.Time) string {
+	if activeUntil != nil && !activeUntil.After(time.Now()) {
+		return "SCHEDULE_IN_PAST"
+	}
+	if activeFrom != nil && activeUntil != nil && !activeFrom.Before(*activeUntil) {
+		return "INVALID_SCHEDULE"
+	}
+
+	return "OK"
+}
+
+// checkShortenedURLOwner responds with 403 Forbidden when the user didn't create the ShortenedURL
+func (h *Handler) checkShortenedURLOwner(c *gin.Context, userID string, id string) bool {
+	isOwner, statusCode, err := h.store.CheckShortenedURLOwner(userID, id)
+	if statusCode != "OK" || err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message":    "Something went wrong",
+			"statusCode": statusCode,
+			"error":      err,
+		})
+		return false
+	}
+	if !isOwner {
+		c.JSON(http.StatusForbidden, gin.H{
+			"message":    "This short URL doesn't belong to you",
+			"statusCode": "NOT_SHORTENEDURL_OWNER",
+		})
+		return false
+	}
+
+	return true
+}
+
+// parseStatsTime parses an RFC 3339 datetime or a date, an empty value returns the fallback
+func parseStatsTime(value string, fallback time.Time) (time.Time, bool) {
+	if value == "" {
+		return fallback, true
+	}
+
+	if parsedTime, err := time.Parse(time.RFC3339, value); err == nil {
+		return parsedTime, true
+	}
+	if parsedTime, err := time.Parse("2006-01-02", value); err == nil {
+		return parsedTime, true
+	}
+
+	return time.Time{}, false
+}
+
 func getTokenFromHeader(c *gin.Context) (string, string, error) {
 	var tokenHeaderData tokenHeader
 	if err := c.ShouldBindHeader(&tokenHeaderData); err != nil {
@@ -70,65 +239,204 @@ func parseTokenWithClaims(tokenString string) (*jwt.Token, error) {
 	return token, err
 }
 
-func checkSecurityToken(c *gin.Context) (bool, string, string, string, error) {
+// sessionClient returns the user agent and IP of the client that starts or refreshes a session
+func sessionClient(c *gin.Context) store.SessionClient {
+	return store.SessionClient{
+		UserAgent: c.Request.UserAgent(),
+		IP:        c.ClientIP(),
+	}
+}
+
+// accessTokenOnly is the scope of the routes that manage the user's account, API keys aren't accepted for them
+const accessTokenOnly = ""
+
+// apiKeyContextKey is where checkSecurityToken keeps the APIKey of a request that used one
+const apiKeyContextKey = "apiKey"
+
+// checkSecurityToken checks the access token or API key of the Authorization header and returns the ID of its user,
+// expired access tokens aren't accepted so the client has to get a new one with its refresh token. API keys need the
+// scope of the route.
+func (h *Handler) checkSecurityToken(c *gin.Context, scope string) (bool, string, string, error) {
 	tokenString, statusCode, err := getTokenFromHeader(c)
 	if tokenString == "" || statusCode != "OK" || err != nil {
-		return false, "", "", statusCode, err
+		return false, "", statusCode, err
 	}
 
-	tokenExists, statusCode, err := store.CheckSecurityTokenExists(tokenString)
+	if strings.HasPrefix(tokenString, store.APIKeyPrefix) {
+		if scope == accessTokenOnly {
+			return false, "", "APIKEY_NOT_ALLOWED", nil
+		}
+
+		apiKey, statusCode, err := h.store.CheckAPIKey(tokenString)
+		if statusCode != "OK" || err != nil {
+			return false, "", statusCode, err
+		}
+		if !apiKey.HasScope(scope) {
+			return false, "", "MISSING_APIKEY_SCOPE", nil
+		}
+
+		c.Set(apiKeyContextKey, apiKey)
+
+		return true, apiKey.UserID, "OK", nil
+	}
+
+	token, err := parseTokenWithClaims(tokenString)
+	if err != nil {
+		var validationErr *jwt.ValidationError
+		if errors.As(err, &validationErr) && validationErr.Errors&jwt.ValidationErrorExpired != 0 {
+			return false, "", "EXPIRED_TOKEN", nil
+		}
+
+		return false, "", "INVALID_TOKEN", err
+	}
+
+	claims, ok := token.Claims.(*store.JWTClaims)
+	if !ok || !token.Valid {
+		return false, "", "INVALID_TOKEN", nil
+	}
+
+	// Revoked tokens are deleted
+	tokenExists, statusCode, err := h.store.CheckSecurityTokenExists(tokenString)
 	if statusCode != "OK" || err != nil || !tokenExists {
-		return false, "", "", statusCode, err
+		return false, "", statusCode, err
 	}
 
-	var newTokenString string
-	token, tokenErr := parseTokenWithClaims(tokenString)
-	claims, _ := token.Claims.(*store.JWTClaims)
-	user, statusCode, err := store.GetUser(claims.Username)
+	user, statusCode, err := h.store.GetUser(claims.Username)
 	if statusCode != "OK" || err != nil {
-		return false, "", "", statusCode, err
+		return false, "", statusCode, err
 	}
-	if tokenErr != nil && strings.Contains(tokenErr.Error(), "token is expired by") {
-		statusCode, err = store.DeleteSecurityToken(tokenString)
-		if statusCode != "OK" || err != nil {
-			return false, "", "", statusCode, err
-		}
 
-		newTokenString, statusCode, err = store.GenerateSecurityToken(user)
-		if statusCode != "OK" || err != nil {
-			return false, "", "", statusCode, err
-		}
+	return true, user.ID, "OK", nil
+}
 
-		token, err = parseTokenWithClaims(newTokenString)
-		if err != nil {
-			return false, "", "", "ERROR_GENERATING_TOKEN", err
+// hasScope checks whether the request may use the scope, which access tokens always may
+func hasScope(c *gin.Context, scope string) bool {
+	apiKey, ok := c.Get(apiKeyContextKey)
+	if !ok {
+		return true
+	}
+
+	return apiKey.(store.APIKey).HasScope(scope)
+}
+
+// respondUnauthorized responds to a request that checkSecurityToken rejected, API keys that can't be used for the route
+// get 403 Forbidden because logging in again doesn't help
+func respondUnauthorized(c *gin.Context, statusCode string, err error) {
+	switch statusCode {
+	case "APIKEY_NOT_ALLOWED":
+		c.JSON(http.StatusForbidden, gin.H{
+			"message":    "API keys can't be used for this request, use an access token",
+			"statusCode": statusCode,
+		})
+	case "MISSING_APIKEY_SCOPE":
+		c.JSON(http.StatusForbidden, gin.H{
+			"message":    "The API key doesn't have the scope of this request",
+			"statusCode": statusCode,
+		})
+	default:
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"message":    "You are not logged in",
+			"statusCode": statusCode,
+			"error":      err,
+		})
+	}
+}
+
+// RedirectShortURL takes a short URL redirects you to the long URL from the database and creates a new ShortenedURLVisitsHistory,
+// password protected short URLs first get a password form that posts back to the short URL
+func (h *Handler) RedirectShortURL(c *gin.Context) {
+	shortURL := c.Request.URL.Path[1:]
+	attemptKey := c.ClientIP() + " " + h.generator.NormalizeShortURL(shortURL)
+
+	// The password of a visitor with too many wrong passwords isn't checked, short URLs without a password still redirect
+	var password string
+	throttled := false
+	if c.Request.Method == http.MethodPost {
+		throttled = !h.passwordAttempts.allowed(attemptKey)
+		if !throttled {
+			password = c.PostForm("password")
 		}
 	}
 
-	if claims, ok := token.Claims.(*store.JWTClaims); !ok || !token.Valid {
-		return false, "", "", "INVALID_TOKEN", err
-	} else if userExists, statusCode, err := store.CheckUserExists(claims.Username); err != nil || !userExists {
-		return false, "", "", statusCode, err
+	// Generated short URLs can be case-insensitive but aliases keep their case, so both are looked up
+	shortenedURL, statusCode, err := h.store.GetLongURL(h.generator.ShortURLCandidates(shortURL), password, h.visitFromRequest(c))
+	switch {
+	case statusCode == "NON_EXISTING_SHORTENEDURL":
+		respondUnavailable(c, http.StatusNotFound, "Not found", "This short URL doesn't exist", statusCode)
+		return
+	case statusCode == "EXPIRED_SHORTENEDURL":
+		goneShortURL(c, "Expired", "This short URL has expired", statusCode)
+		return
+	case statusCode == "EXHAUSTED_SHORTENEDURL":
+		goneShortURL(c, "No visits left", "This short URL has reached its maximum number of visits", statusCode)
+		return
+	case statusCode == "NOT_YET_ACTIVE_SHORTENEDURL" || statusCode == "NO_LONGER_ACTIVE_SHORTENEDURL":
+		inactiveShortURL(c, shortenedURL, statusCode)
+		return
+	case statusCode == "PASSWORD_REQUIRED" && throttled:
+		passwordForm(c, http.StatusTooManyRequests, "Too many wrong passwords, try again later")
+		return
+	case statusCode == "PASSWORD_REQUIRED":
+		passwordForm(c, http.StatusOK, "")
+		return
+	case statusCode == "WRONG_PASSWORD":
+		h.passwordAttempts.fail(attemptKey)
+		passwordForm(c, http.StatusForbidden, "Wrong password")
+		return
+	case statusCode != "OK" || err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message":    "Something went wrong",
+			"statusCode": statusCode,
+			"error":      err,
+		})
+		return
 	}
 
-	if newTokenString != "" {
-		return true, newTokenString, user.ID, "OK", nil
+	if password != "" {
+		h.passwordAttempts.reset(attemptKey)
 	}
 
-	return true, "", user.ID, "OK", nil
+	h.redirectLongURL(c, shortenedURL)
 }
 
-// RedirectShortURL takes a short URL redirects you to the long URL from the database and creates a new ShortenedURLVisitsHistory
-func RedirectShortURL(c *gin.Context) {
-	shortURL := c.Request.URL.Path[1:]
-	longURL := store.GetLongURL(shortURL)
+// inactiveShortURL redirects to the INACTIVE_LINK_FALLBACK_URL environment variable or shows when the short URL is
+// available
+func inactiveShortURL(c *gin.Context, shortenedURL store.ShortenedURL, statusCode string) {
+	if fallbackURL := os.Getenv("INACTIVE_LINK_FALLBACK_URL"); fallbackURL != "" {
+		c.Redirect(302, fallbackURL)
+		return
+	}
+
+	const timeFormat = "Monday, January 2, 2006 at 15:04 MST"
+	if statusCode == "NOT_YET_ACTIVE_SHORTENEDURL" {
+		message := "This short URL isn't available yet."
+		if shortenedURL.ActiveFrom != nil {
+			message = "This short URL will be available from " + shortenedURL.ActiveFrom.UTC().Format(timeFormat) + "."
+		}
+		respondUnavailable(c, http.StatusForbidden, "Not yet available", message, statusCode)
+		return
+	}
 
-	c.Redirect(302, longURL)
+	message := "This short URL is no longer available."
+	if shortenedURL.ActiveUntil != nil {
+		message = "This short URL was available until " + shortenedURL.ActiveUntil.UTC().Format(timeFormat) + "."
+	}
+	respondUnavailable(c, http.StatusGone, "No longer available", message, statusCode)
+}
+
+// goneShortURL redirects to the EXPIRED_LINK_FALLBACK_URL environment variable or responds with 410 Gone
+func goneShortURL(c *gin.Context, title string, message string, statusCode string) {
+	if fallbackURL := os.Getenv("EXPIRED_LINK_FALLBACK_URL"); fallbackURL != "" {
+		c.Redirect(302, fallbackURL)
+		return
+	}
+
+	respondUnavailable(c, http.StatusGone, title, message, statusCode)
 }
 
 // UpdateShortURL takes a name and a long URL and updates the ShortenedURL in the database
-func UpdateShortURL(c *gin.Context) {
-	ok, newToken, _, statusCode, err := checkSecurityToken(c)
+func (h *Handler) UpdateShortURL(c *gin.Context) {
+	ok, tokenUserID, statusCode, err := h.checkSecurityToken(c, store.ScopeLinksWrite)
 	if ok {
 		var urlData urlUpdateRequest
 		if err := c.ShouldBindJSON(&urlData); err != nil {
@@ -136,11 +444,115 @@ func UpdateShortURL(c *gin.Context) {
 			return
 		}
 
+		if !h.checkShortenedURLOwner(c, tokenUserID, c.Param("id")) {
+			return
+		}
+
+		if urlData.LongURL != "" {
+			if statusCode := shortener.ValidateLongURL(urlData.LongURL); statusCode != "OK" {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"message":    "Invalid long URL, use an absolute http or https URL",
+					"statusCode": statusCode,
+				})
+				return
+			}
+		}
+
+		var clearColumns []string
+		expiresAt, statusCode := getExpiration(urlData.ExpiresAt, urlData.TTL)
+		if statusCode == "OK" && urlData.ClearExpiration {
+			if expiresAt != nil {
+				statusCode = "CONFLICTING_EXPIRATION"
+			}
+			clearColumns = append(clearColumns, "ExpiresAt")
+		}
+		if statusCode != "OK" {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message":    "Invalid expiration, give a future expiresAt, a ttl or clearExpiration",
+				"statusCode": statusCode,
+			})
+			return
+		}
+		// A new or cleared expiration brings an archived short URL back
+		if expiresAt != nil || urlData.ClearExpiration {
+			clearColumns = append(clearColumns, "ArchivedAt")
+		}
+
+		if urlData.ClearMaxVisits {
+			if urlData.MaxVisits != 0 {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"message":    "Give either maxVisits or clearMaxVisits",
+					"statusCode": "CONFLICTING_MAX_VISITS",
+				})
+				return
+			}
+			clearColumns = append(clearColumns, "MaxVisits")
+		}
+
+		if urlData.ClearPassword {
+			if urlData.Password != "" {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"message":    "Give either password or clearPassword",
+					"statusCode": "CONFLICTING_PASSWORD",
+				})
+				return
+			}
+			clearColumns = append(clearColumns, "Password")
+		}
+
+		statusCode = checkSchedule(urlData.ActiveFrom, urlData.ActiveUntil)
+		if statusCode == "OK" && urlData.ClearSchedule && (urlData.ActiveFrom != nil || urlData.ActiveUntil != nil) {
+			statusCode = "CONFLICTING_SCHEDULE"
+		}
+		if statusCode != "OK" {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message":    "Invalid schedule, activeFrom must be before a future activeUntil or give clearSchedule",
+				"statusCode": statusCode,
+			})
+			return
+		}
+		if urlData.ClearSchedule {
+			clearColumns = append(clearColumns, "ActiveFrom", "ActiveUntil")
+		}
+
+		if urlData.ClearRedirectType {
+			if urlData.RedirectType != 0 {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"message":    "Give either redirectType or clearRedirectType",
+					"statusCode": "CONFLICTING_REDIRECT_TYPE",
+				})
+				return
+			}
+			clearColumns = append(clearColumns, "RedirectType")
+		}
+
 		var shortenedURL = store.ShortenedURL{
-			ID:   c.Param("id"),
-			Name: urlData.Name,
+			ID:           c.Param("id"),
+			Name:         urlData.Name,
+			LongURL:      urlData.LongURL,
+			ExpiresAt:    expiresAt,
+			MaxVisits:    urlData.MaxVisits,
+			Password:     urlData.Password,
+			ActiveFrom:   urlData.ActiveFrom,
+			ActiveUntil:  urlData.ActiveUntil,
+			RedirectType: urlData.RedirectType,
+		}
+		if len(clearColumns) == 0 && shortenedURL == (store.ShortenedURL{ID: shortenedURL.ID}) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message":    "Nothing to update",
+				"statusCode": "EMPTY_UPDATE",
+			})
+			return
+		}
+
+		statusCode, err = h.store.UpdateShortenedURL(shortenedURL, tokenUserID, clearColumns...)
+		if statusCode == "INVALID_SCHEDULE" {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message":    "Invalid schedule, activeFrom must be before the activeUntil of the short URL",
+				"statusCode": statusCode,
+			})
+			return
 		}
-		statusCode, err = store.UpdateShortenedURL(shortenedURL)
 		if statusCode != "OK" || err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{
 				"message":    "Something went wrong",
@@ -153,24 +565,23 @@ func UpdateShortURL(c *gin.Context) {
 		c.JSON(200, gin.H{
 			"message":    "Short URL updated successfully",
 			"statusCode": statusCode,
-			"newToken":   newToken,
 		})
 	} else {
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"message":    "You are not logged in",
-			"statusCode": statusCode,
-			"error":      err,
-		})
+		respondUnauthorized(c, statusCode, err)
 	}
 }
 
 // DeleteShortURL deletes the ShortenedURL in the database
-func DeleteShortURL(c *gin.Context) {
-	ok, newToken, _, statusCode, err := checkSecurityToken(c)
+func (h *Handler) DeleteShortURL(c *gin.Context) {
+	ok, tokenUserID, statusCode, err := h.checkSecurityToken(c, store.ScopeLinksWrite)
 	if ok {
 		id := c.Param("id")
 
-		statusCode, err := store.DeleteShortenedURL(id)
+		if !h.checkShortenedURLOwner(c, tokenUserID, id) {
+			return
+		}
+
+		statusCode, err := h.store.DeleteShortenedURL(id)
 		if statusCode != "OK" || err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{
 				"message":    "Something went wrong",
@@ -183,20 +594,15 @@ func DeleteShortURL(c *gin.Context) {
 		c.JSON(200, gin.H{
 			"message":    "Short URL deleted successfully",
 			"statusCode": statusCode,
-			"newToken":   newToken,
 		})
 	} else {
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"message":    "You are not logged in",
-			"statusCode": statusCode,
-			"error":      err,
-		})
+		respondUnauthorized(c, statusCode, err)
 	}
 }
 
-// CreateShortURL takes a name, a long URL and a user ID and creates a new ShortenedURL
-func CreateShortURL(c *gin.Context) {
-	ok, newToken, _, statusCode, err := checkSecurityToken(c)
+// CreateShortURL takes a name, a long URL and an optional alias and creates a new ShortenedURL for the user of the token
+func (h *Handler) CreateShortURL(c *gin.Context) {
+	ok, tokenUserID, statusCode, err := h.checkSecurityToken(c, store.ScopeLinksWrite)
 	if ok {
 		var creationRequest urlCreationRequest
 		if err := c.ShouldBindJSON(&creationRequest); err != nil {
@@ -204,12 +610,74 @@ func CreateShortURL(c *gin.Context) {
 			return
 		}
 
-		shortURL := shortener.GenerateShortURL(creationRequest.LongURL, creationRequest.UserID)
-		shortenedURL, statusCode, err := store.SaveURL(shortURL, creationRequest.Name, creationRequest.LongURL, creationRequest.UserID)
+		if creationRequest.UserID != "" && compareUserIDWithToken(c, creationRequest.UserID, tokenUserID) == false {
+			return
+		}
+		creationRequest.UserID = tokenUserID
+
+		if statusCode := shortener.ValidateLongURL(creationRequest.LongURL); statusCode != "OK" {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message":    "Invalid long URL, use an absolute http or https URL",
+				"statusCode": statusCode,
+			})
+			return
+		}
+
+		expiresAt, statusCode := getExpiration(creationRequest.ExpiresAt, creationRequest.TTL)
+		if statusCode != "OK" {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message":    "Invalid expiration, give either a future expiresAt or a ttl",
+				"statusCode": statusCode,
+			})
+			return
+		}
+
+		statusCode = checkSchedule(creationRequest.ActiveFrom, creationRequest.ActiveUntil)
+		if statusCode != "OK" {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message":    "Invalid schedule, activeFrom must be before a future activeUntil",
+				"statusCode": statusCode,
+			})
+			return
+		}
+
+		var shortenedURL = store.ShortenedURL{
+			Name:         creationRequest.Name,
+			LongURL:      creationRequest.LongURL,
+			ExpiresAt:    expiresAt,
+			MaxVisits:    creationRequest.MaxVisits,
+			Password:     creationRequest.Password,
+			ActiveFrom:   creationRequest.ActiveFrom,
+			ActiveUntil:  creationRequest.ActiveUntil,
+			RedirectType: creationRequest.RedirectType,
+		}
+
+		var (
+			existing bool
+			err      error
+		)
+		if creationRequest.Alias != "" {
+			statusCode = shortener.ValidateAlias(creationRequest.Alias)
+			if statusCode != "OK" {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"message":    "Invalid alias, use " + shortener.AliasRules,
+					"statusCode": statusCode,
+				})
+				return
+			}
+
+			shortenedURL.ShortURL = creationRequest.Alias
+			shortenedURL, statusCode, err = h.store.SaveURL(shortenedURL, creationRequest.UserID)
+		} else {
+			shortenedURL, existing, statusCode, err = h.createGeneratedShortURL(creationRequest, shortenedURL)
+		}
 		if statusCode != "OK" || err != nil {
 			status := http.StatusBadRequest
 			if statusCode == "NON_EXISTING_USER" {
 				status = http.StatusUnauthorized
+			} else if statusCode == "DUPLICATE_URL" && creationRequest.Alias != "" {
+				status = http.StatusConflict
+				statusCode = "DUPLICATE_ALIAS"
 			}
 
 			c.JSON(status, gin.H{
@@ -220,32 +688,141 @@ func CreateShortURL(c *gin.Context) {
 			return
 		}
 
+		message := "Short URL created successfully"
+		if existing {
+			message = "Short URL already exists"
+		}
+
 		c.JSON(200, gin.H{
-			"message":      "Short URL created successfully",
+			"message":      message,
 			"statusCode":   statusCode,
 			"shortenedURL": shortenedURL,
-			"newToken":     newToken,
+			"existing":     existing,
 		})
 	} else {
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"message":    "You are not logged in",
+		respondUnauthorized(c, statusCode, err)
+	}
+}
+
+// createGeneratedShortURL saves the given shortenedURL with a generated short URL, if the user already shortened the
+// long URL, OnDuplicate decides whether that's an error, the existing ShortenedURL is returned or another one is created
+func (h *Handler) createGeneratedShortURL(request urlCreationRequest, shortenedURL store.ShortenedURL) (store.ShortenedURL, bool, string, error) {
+	if request.OnDuplicate != "new" {
+		existingURL, statusCode, err := h.store.GetUserShortenedURLByLongURL(request.UserID, request.LongURL)
+		if statusCode == "OK" {
+			if request.OnDuplicate == "reuse" {
+				return existingURL, true, "OK", nil
+			}
+
+			return store.ShortenedURL{}, false, "DUPLICATE_URL", nil
+		} else if statusCode != "NON_EXISTING_SHORTENEDURL" {
+			return store.ShortenedURL{}, false, statusCode, err
+		}
+	}
+
+	// The hash strategy needs a salt to generate a different short URL for a long URL that the user already shortened
+	var salt string
+	if request.OnDuplicate == "new" {
+		salt = uuid.NewV4().String()
+	}
+
+	shortenedURL, statusCode, err := h.saveGeneratedShortURL(shortenedURL, request.UserID, salt)
+	return shortenedURL, false, statusCode, err
+}
+
+// saveGeneratedShortURL saves the given shortenedURL with a generated short URL, when that short URL is already taken
+// it retries with the next one
+func (h *Handler) saveGeneratedShortURL(shortenedURL store.ShortenedURL, userID string, salt string) (store.ShortenedURL, string, error) {
+	for attempt := 0; attempt < shortener.MaxAttempts; attempt++ {
+		shortURL, err := h.generator.Generate(shortenedURL.LongURL, userID+salt, attempt)
+		if err != nil {
+			return store.ShortenedURL{}, "ERROR_GENERATING_SHORTURL", err
+		}
+		shortenedURL.ShortURL = shortURL
+
+		// Only paths that could be an alias are looked up, so a generated reserved word is skipped like a taken short URL
+		if shortener.ValidateAlias(shortURL) != "OK" {
+			continue
+		}
+
+		savedURL, statusCode, err := h.store.SaveURL(shortenedURL, userID)
+		if statusCode != "DUPLICATE_URL" {
+			return savedURL, statusCode, err
+		}
+	}
+
+	return store.ShortenedURL{}, "ERROR_GENERATING_SHORTURL", nil
+}
+
+// GetShortURLStats takes a ShortenedURL ID and returns its visits counted per hour, day or week
+func (h *Handler) GetShortURLStats(c *gin.Context) {
+	ok, tokenUserID, statusCode, err := h.checkSecurityToken(c, store.ScopeAnalyticsRead)
+	if ok {
+		id := c.Param("id")
+
+		var statsData statsRequest
+		if err := c.ShouldBindQuery(&statsData); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		interval := statsData.Interval
+		if interval == "" {
+			interval = "day"
+		}
+
+		to, toOK := parseStatsTime(statsData.To, time.Now())
+		from, fromOK := parseStatsTime(statsData.From, to.Add(-defaultStatsRange))
+		if !toOK || !fromOK || !from.Before(to) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message":    "Invalid time range, from and to are RFC 3339 datetimes or dates and from has to be before to",
+				"statusCode": "INVALID_TIME_RANGE",
+			})
+			return
+		}
+		if to.Sub(from)/statsIntervals[interval] > maxStatsBuckets {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message":    "Too many buckets, use a shorter time range or a longer interval",
+				"statusCode": "TOO_MANY_BUCKETS",
+			})
+			return
+		}
+
+		if !h.checkShortenedURLOwner(c, tokenUserID, id) {
+			return
+		}
+
+		stats, statusCode, err := h.store.GetShortenedURLVisitStats(id, from, to, interval)
+		if statusCode != "OK" || err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message":    "Something went wrong",
+				"statusCode": statusCode,
+				"error":      err,
+			})
+			return
+		}
+
+		c.JSON(200, gin.H{
 			"statusCode": statusCode,
-			"error":      err,
+			"stats":      stats,
 		})
+	} else {
+		respondUnauthorized(c, statusCode, err)
 	}
 }
 
 // GetUserShortenedURLs takes a user ID and returns the user's ShortenedURLs
-func GetUserShortenedURLs(c *gin.Context) {
-	ok, newToken, tokenUserID, statusCode, err := checkSecurityToken(c)
+func (h *Handler) GetUserShortenedURLs(c *gin.Context) {
+	ok, tokenUserID, statusCode, err := h.checkSecurityToken(c, store.ScopeLinksRead)
 	if ok {
-		userID := c.Param("userID")
+		// The route calls the user ID :id because Gin needs the same wildcard name as /api/short-urls/:id/stats
+		userID := c.Param("id")
 
 		if compareUserIDWithToken(c, userID, tokenUserID) == false {
 			return
 		}
 
-		urls, statusCode, err := store.GetUserShortenedURLs(userID)
+		urls, statusCode, err := h.store.GetUserShortenedURLs(userID)
 		if statusCode != "OK" || err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{
 				"message":    "Something went wrong",
@@ -255,23 +832,25 @@ func GetUserShortenedURLs(c *gin.Context) {
 			return
 		}
 
+		// The analytics need their own scope
+		if !hasScope(c, store.ScopeAnalyticsRead) {
+			for i := range urls {
+				urls[i].Analytics = nil
+			}
+		}
+
 		c.JSON(200, gin.H{
 			"statusCode": statusCode,
 			"urls":       urls,
-			"newToken":   newToken,
 		})
 	} else {
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"message":    "You are not logged in",
-			"statusCode": statusCode,
-			"error":      err,
-		})
+		respondUnauthorized(c, statusCode, err)
 	}
 }
 
 // GetUser returns user information by user ID
-func GetUser(c *gin.Context) {
-	ok, newToken, tokenUserID, statusCode, err := checkSecurityToken(c)
+func (h *Handler) GetUser(c *gin.Context) {
+	ok, tokenUserID, statusCode, err := h.checkSecurityToken(c, accessTokenOnly)
 	if ok {
 		userID := c.Param("userID")
 
@@ -279,7 +858,7 @@ func GetUser(c *gin.Context) {
 			return
 		}
 
-		user, statusCode, err := store.GetUser(userID)
+		user, statusCode, err := h.store.GetUser(userID)
 		if statusCode != "OK" || err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{
 				"message":    "Something went wrong",
@@ -292,20 +871,198 @@ func GetUser(c *gin.Context) {
 		c.JSON(200, gin.H{
 			"statusCode": statusCode,
 			"user":       user,
-			"newToken":   newToken,
 		})
 	} else {
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"message":    "You are not logged in",
+		respondUnauthorized(c, statusCode, err)
+	}
+}
+
+// GetUserSessions returns the sessions of the User that are still logged in, including the current one
+func (h *Handler) GetUserSessions(c *gin.Context) {
+	ok, tokenUserID, statusCode, err := h.checkSecurityToken(c, accessTokenOnly)
+	if ok {
+		userID := c.Param("userID")
+
+		if compareUserIDWithToken(c, userID, tokenUserID) == false {
+			return
+		}
+
+		tokenString, statusCode, err := getTokenFromHeader(c)
+		if tokenString == "" || statusCode != "OK" || err != nil {
+			return
+		}
+
+		sessions, statusCode, err := h.store.GetUserSessions(userID, tokenString)
+		if statusCode != "OK" || err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message":    "Something went wrong",
+				"statusCode": statusCode,
+				"error":      err,
+			})
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"statusCode": statusCode,
+			"sessions":   sessions,
+		})
+	} else {
+		respondUnauthorized(c, statusCode, err)
+	}
+}
+
+// DeleteUserSession logs a session of the User out, for example on a lost device
+func (h *Handler) DeleteUserSession(c *gin.Context) {
+	ok, tokenUserID, statusCode, err := h.checkSecurityToken(c, accessTokenOnly)
+	if ok {
+		userID := c.Param("userID")
+
+		if compareUserIDWithToken(c, userID, tokenUserID) == false {
+			return
+		}
+
+		statusCode, err := h.store.RevokeUserSession(userID, c.Param("sessionID"))
+		if statusCode != "OK" || err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message":    "Something went wrong",
+				"statusCode": statusCode,
+				"error":      err,
+			})
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"message":    "Session deleted successfully",
 			"statusCode": statusCode,
-			"error":      err,
 		})
+	} else {
+		respondUnauthorized(c, statusCode, err)
+	}
+}
+
+// CreateAPIKey takes a name, scopes and an optional expiration and creates a new APIKey for the User, the key is only
+// returned this once
+func (h *Handler) CreateAPIKey(c *gin.Context) {
+	ok, tokenUserID, statusCode, err := h.checkSecurityToken(c, accessTokenOnly)
+	if ok {
+		userID := c.Param("userID")
+
+		if compareUserIDWithToken(c, userID, tokenUserID) == false {
+			return
+		}
+
+		var creationRequest apiKeyCreationRequest
+		if err := c.ShouldBindJSON(&creationRequest); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		expiresAt, statusCode := getExpiration(creationRequest.ExpiresAt, creationRequest.TTL)
+		if statusCode != "OK" {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message":    "Invalid expiration, give either a future expiresAt or a ttl",
+				"statusCode": statusCode,
+			})
+			return
+		}
+
+		// A scope that is given twice is only saved once
+		var scopes []string
+		givenScopes := make(map[string]bool)
+		for _, scope := range creationRequest.Scopes {
+			if !givenScopes[scope] {
+				givenScopes[scope] = true
+				scopes = append(scopes, scope)
+			}
+		}
+
+		apiKey, key, statusCode, err := h.store.SaveAPIKey(store.APIKey{
+			UserID:    userID,
+			Name:      creationRequest.Name,
+			Scopes:    scopes,
+			ExpiresAt: expiresAt,
+		})
+		if statusCode != "OK" || err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message":    "Something went wrong",
+				"statusCode": statusCode,
+				"error":      err,
+			})
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"message":    "API key created successfully, copy the key because it can't be shown again",
+			"statusCode": statusCode,
+			"apiKey":     apiKey,
+			"key":        key,
+		})
+	} else {
+		respondUnauthorized(c, statusCode, err)
+	}
+}
+
+// GetUserAPIKeys returns the APIKeys of the User without their keys
+func (h *Handler) GetUserAPIKeys(c *gin.Context) {
+	ok, tokenUserID, statusCode, err := h.checkSecurityToken(c, accessTokenOnly)
+	if ok {
+		userID := c.Param("userID")
+
+		if compareUserIDWithToken(c, userID, tokenUserID) == false {
+			return
+		}
+
+		apiKeys, statusCode, err := h.store.GetUserAPIKeys(userID)
+		if statusCode != "OK" || err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message":    "Something went wrong",
+				"statusCode": statusCode,
+				"error":      err,
+			})
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"statusCode": statusCode,
+			"apiKeys":    apiKeys,
+		})
+	} else {
+		respondUnauthorized(c, statusCode, err)
+	}
+}
+
+// DeleteAPIKey revokes an APIKey of the User
+func (h *Handler) DeleteAPIKey(c *gin.Context) {
+	ok, tokenUserID, statusCode, err := h.checkSecurityToken(c, accessTokenOnly)
+	if ok {
+		userID := c.Param("userID")
+
+		if compareUserIDWithToken(c, userID, tokenUserID) == false {
+			return
+		}
+
+		statusCode, err := h.store.DeleteAPIKey(userID, c.Param("apiKeyID"))
+		if statusCode != "OK" || err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message":    "Something went wrong",
+				"statusCode": statusCode,
+				"error":      err,
+			})
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"message":    "API key deleted successfully",
+			"statusCode": statusCode,
+		})
+	} else {
+		respondUnauthorized(c, statusCode, err)
 	}
 }
 
 // UpdateUser takes a first name, a last name, a username, an email and a password and updates the User in the database
-func UpdateUser(c *gin.Context) {
-	ok, newToken, tokenUserID, statusCode, err := checkSecurityToken(c)
+func (h *Handler) UpdateUser(c *gin.Context) {
+	ok, tokenUserID, statusCode, err := h.checkSecurityToken(c, accessTokenOnly)
 	if ok {
 		userID := c.Param("userID")
 
@@ -333,34 +1090,30 @@ func UpdateUser(c *gin.Context) {
 			return
 		}
 
-		newToken, statusCode, err = store.UpdateUser(user, tokenString)
+		newToken, statusCode, err := h.store.UpdateUser(user, tokenString)
 		if statusCode != "OK" || err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{
 				"message":    "Something went wrong",
 				"statusCode": statusCode,
 				"error":      err,
-				"newToken":   newToken,
 			})
 			return
 		}
 
+		// The username is in the payload of the token, so changing it replaces the token
 		c.JSON(200, gin.H{
 			"message":    "User updated successfully",
 			"statusCode": statusCode,
 			"newToken":   newToken,
 		})
 	} else {
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"message":    "You are not logged in",
-			"statusCode": statusCode,
-			"error":      err,
-		})
+		respondUnauthorized(c, statusCode, err)
 	}
 }
 
 // DeleteUser deletes the User in the database
-func DeleteUser(c *gin.Context) {
-	ok, newToken, tokenUserID, statusCode, err := checkSecurityToken(c)
+func (h *Handler) DeleteUser(c *gin.Context) {
+	ok, tokenUserID, statusCode, err := h.checkSecurityToken(c, accessTokenOnly)
 	if ok {
 		userID := c.Param("userID")
 
@@ -368,7 +1121,7 @@ func DeleteUser(c *gin.Context) {
 			return
 		}
 
-		statusCode, err := store.DeleteUser(userID)
+		statusCode, err := h.store.DeleteUser(userID)
 		if statusCode != "OK" || err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{
 				"message":    "Something went wrong",
@@ -381,26 +1134,21 @@ func DeleteUser(c *gin.Context) {
 		c.JSON(200, gin.H{
 			"message":    "User deleted successfully",
 			"statusCode": statusCode,
-			"newToken":   newToken,
 		})
 	} else {
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"message":    "You are not logged in",
-			"statusCode": statusCode,
-			"error":      err,
-		})
+		respondUnauthorized(c, statusCode, err)
 	}
 }
 
 // CreateUser takes a first name, a last name, a username, an email and a password and creates a new User and returns a new user token
-func CreateUser(c *gin.Context) {
+func (h *Handler) CreateUser(c *gin.Context) {
 	var user store.User
 	if err := c.ShouldBindJSON(&user); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	token, userID, statusCode, err := store.SaveUser(user)
+	tokenPair, userID, statusCode, err := h.store.SaveUser(user, sessionClient(c))
 	if statusCode != "OK" || err != nil {
 		c.JSON(401, gin.H{
 			"message":    "Something went wrong",
@@ -411,15 +1159,16 @@ func CreateUser(c *gin.Context) {
 	}
 
 	c.JSON(200, gin.H{
-		"message":    "User created successfully",
-		"statusCode": statusCode,
-		"token":      token,
-		"userID":     userID,
+		"message":      "User created successfully",
+		"statusCode":   statusCode,
+		"token":        tokenPair.AccessToken,
+		"refreshToken": tokenPair.RefreshToken,
+		"userID":       userID,
 	})
 }
 
 // CheckUserLogin takes a username or email and a password and checks if the user exists and provided a correct password and returns a new user token
-func CheckUserLogin(c *gin.Context) {
+func (h *Handler) CheckUserLogin(c *gin.Context) {
 	var userData userLoginRequest
 	if err := c.ShouldBindJSON(&userData); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -432,7 +1181,7 @@ func CheckUserLogin(c *gin.Context) {
 		Password: userData.Password,
 	}
 
-	token, userID, statusCode, err := store.CheckLogin(user)
+	tokenPair, userID, statusCode, err := h.store.CheckLogin(user, sessionClient(c))
 	if statusCode != "OK" || err != nil {
 		c.JSON(401, gin.H{
 			"message":    "Something went wrong",
@@ -443,16 +1192,78 @@ func CheckUserLogin(c *gin.Context) {
 	}
 
 	c.JSON(200, gin.H{
-		"message":    "User logged in successfully",
+		"message":      "User logged in successfully",
+		"statusCode":   statusCode,
+		"token":        tokenPair.AccessToken,
+		"refreshToken": tokenPair.RefreshToken,
+		"userID":       userID,
+	})
+}
+
+// RefreshToken takes a refresh token and returns a new access token and refresh token, a refresh token can only be used
+// once
+func (h *Handler) RefreshToken(c *gin.Context) {
+	var request tokenRefreshRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tokenPair, statusCode, err := h.store.RefreshTokenPair(request.RefreshToken, sessionClient(c))
+	if statusCode != "OK" || err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"message":    "The refresh token can't be used anymore, log in again",
+			"statusCode": statusCode,
+			"error":      err,
+		})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"message":      "Token refreshed successfully",
+		"statusCode":   statusCode,
+		"token":        tokenPair.AccessToken,
+		"refreshToken": tokenPair.RefreshToken,
+	})
+}
+
+// Logout revokes the access tokens and refresh tokens of a login by its refresh token or its access token
+func (h *Handler) Logout(c *gin.Context) {
+	var request logoutRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	var statusCode string
+	var err error
+	if request.RefreshToken != "" {
+		statusCode, err = h.store.RevokeRefreshToken(request.RefreshToken)
+	} else {
+		var tokenString string
+		tokenString, statusCode, err = getTokenFromHeader(c)
+		if tokenString != "" && statusCode == "OK" && err == nil {
+			statusCode, err = h.store.RevokeSecurityToken(tokenString)
+		}
+	}
+	if statusCode != "OK" || err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"message":    "You are not logged in",
+			"statusCode": statusCode,
+			"error":      err,
+		})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"message":    "User logged out successfully",
 		"statusCode": statusCode,
-		"token":      token,
-		"userID":     userID,
 	})
 }
 
-// NotFound returns a 404 with a "Not found" message
+// NotFound returns a 404 with a "Not found" message, as a page for browsers
 func NotFound(c *gin.Context) {
-	c.JSON(404, gin.H{
-		"message": "Not found",
-	})
+	respondUnavailable(c, 404, "Not found", "Not found", "")
 }
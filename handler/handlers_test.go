@@ -0,0 +1,340 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/devlaminckduncan/url-shortener/shortener"
+	"github.com/devlaminckduncan/url-shortener/store"
+	"github.com/gin-gonic/gin"
+)
+
+// apiKeyStore is a Store that only knows one API key, the other methods aren't implemented
+type apiKeyStore struct {
+	store.Store
+	apiKey store.APIKey
+}
+
+func (s apiKeyStore) CheckAPIKey(key string) (store.APIKey, string, error) {
+	if key != "usk_test" {
+		return store.APIKey{}, "NON_EXISTING_APIKEY", nil
+	}
+
+	return s.apiKey, "OK", nil
+}
+
+// requestWithAPIKey returns the status and statusCode of a GET request that used the API key
+func requestWithAPIKey(route func(c *gin.Context), apiKey string) (int, string) {
+	return sendWithAPIKey(http.MethodGet, "/", "/", route, apiKey, "")
+}
+
+// sendWithAPIKey returns the status and statusCode of a request to the path of the route that used the API key
+func sendWithAPIKey(method string, routePath string, path string, route func(c *gin.Context), apiKey string, body string) (int, string) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Handle(method, routePath, route)
+
+	request := httptest.NewRequest(method, path, strings.NewReader(body))
+	request.Header.Set("Authorization", "Bearer "+apiKey)
+	if body != "" {
+		request.Header.Set("Content-Type", gin.MIMEJSON)
+	}
+	recorder := httptest.NewRecorder()
+	r.ServeHTTP(recorder, request)
+
+	var response struct {
+		StatusCode string `json:"statusCode"`
+	}
+	json.Unmarshal(recorder.Body.Bytes(), &response)
+
+	return recorder.Code, response.StatusCode
+}
+
+func TestAPIKeysThatCantBeUsedAreForbidden(t *testing.T) {
+	h := &Handler{store: apiKeyStore{apiKey: store.APIKey{UserID: "user", Scopes: []string{store.ScopeLinksRead}}}}
+
+	tests := []struct {
+		name       string
+		route      func(c *gin.Context)
+		apiKey     string
+		status     int
+		statusCode string
+	}{
+		{"account route", h.GetUser, "usk_test", http.StatusForbidden, "APIKEY_NOT_ALLOWED"},
+		{"missing scope", h.GetShortURLStats, "usk_test", http.StatusForbidden, "MISSING_APIKEY_SCOPE"},
+		{"unknown API key", h.GetShortURLStats, "usk_unknown", http.StatusUnauthorized, "NON_EXISTING_APIKEY"},
+	}
+
+	for _, test := range tests {
+		status, statusCode := requestWithAPIKey(test.route, test.apiKey)
+		if status != test.status || statusCode != test.statusCode {
+			t.Errorf("%s: got %d %s, want %d %s", test.name, status, statusCode, test.status, test.statusCode)
+		}
+	}
+}
+
+// ownerStore is an apiKeyStore where the user of the API key owns only the short URLs of its map, it counts the short URLs
+// it updated or deleted
+type ownerStore struct {
+	apiKeyStore
+	owned   map[string]bool
+	changed *int
+}
+
+func (s ownerStore) CheckShortenedURLOwner(userID string, id string) (bool, string, error) {
+	return userID == s.apiKey.UserID && s.owned[id], "OK", nil
+}
+
+func (s ownerStore) UpdateShortenedURL(shortenedURL store.ShortenedURL, changedBy string, clearColumns ...string) (string, error) {
+	*s.changed++
+	return "OK", nil
+}
+
+func (s ownerStore) DeleteShortenedURL(id string) (string, error) {
+	*s.changed++
+	return "OK", nil
+}
+
+func TestOnlyTheOwnerCanChangeAShortURL(t *testing.T) {
+	changed := 0
+	h := &Handler{store: ownerStore{
+		apiKeyStore: apiKeyStore{apiKey: store.APIKey{UserID: "user", Scopes: []string{store.ScopeLinksWrite}}},
+		owned:       map[string]bool{"mine": true},
+		changed:     &changed,
+	}}
+	update := `{"name": "Renamed"}`
+
+	tests := []struct {
+		name       string
+		method     string
+		route      func(c *gin.Context)
+		id         string
+		status     int
+		statusCode string
+	}{
+		{"update of another user's short URL", http.MethodPut, h.UpdateShortURL, "theirs", http.StatusForbidden, "NOT_SHORTENEDURL_OWNER"},
+		{"deletion of another user's short URL", http.MethodDelete, h.DeleteShortURL, "theirs", http.StatusForbidden, "NOT_SHORTENEDURL_OWNER"},
+		{"update of an own short URL", http.MethodPut, h.UpdateShortURL, "mine", http.StatusOK, "OK"},
+		{"deletion of an own short URL", http.MethodDelete, h.DeleteShortURL, "mine", http.StatusOK, "OK"},
+	}
+
+	for _, test := range tests {
+		status, statusCode := sendWithAPIKey(test.method, "/:id", "/"+test.id, test.route, "usk_test", update)
+		if status != test.status || statusCode != test.statusCode {
+			t.Errorf("%s: got %d %s, want %d %s", test.name, status, statusCode, test.status, test.statusCode)
+		}
+	}
+
+	if changed != 2 {
+		t.Errorf("The store changed %d short URLs, want only the 2 of the owner", changed)
+	}
+}
+
+// redirectStore is a Store that only knows the short URLs of its map, the other methods aren't implemented
+type redirectStore struct {
+	store.Store
+	shortURLs map[string]fakeShortURL
+}
+
+// fakeShortURL is a short URL of a redirectStore, GetLongURL returns its status code once the password matches
+type fakeShortURL struct {
+	shortenedURL store.ShortenedURL
+	statusCode   string
+	password     string
+}
+
+func (s redirectStore) GetLongURL(shortURLs []string, password string, visit store.ShortenedURLVisitsHistory) (store.ShortenedURL, string, error) {
+	for _, shortURL := range shortURLs {
+		fake, ok := s.shortURLs[shortURL]
+		if !ok {
+			continue
+		}
+
+		switch {
+		case fake.password != "" && password == "":
+			return fake.shortenedURL, "PASSWORD_REQUIRED", nil
+		case fake.password != "" && password != fake.password:
+			return fake.shortenedURL, "WRONG_PASSWORD", nil
+		case fake.statusCode != "":
+			return fake.shortenedURL, fake.statusCode, nil
+		}
+
+		return fake.shortenedURL, "OK", nil
+	}
+
+	return store.ShortenedURL{}, "NON_EXISTING_SHORTENEDURL", nil
+}
+
+// newRedirectHandler returns a Handler that redirects the short URLs
+func newRedirectHandler(t *testing.T, shortURLs map[string]fakeShortURL) *Handler {
+	t.Helper()
+
+	generator, err := shortener.NewGenerator("random", "base58", 8, nil)
+	if err != nil {
+		t.Fatalf("NewGenerator returned %v", err)
+	}
+
+	os.Setenv("IP_HASH_SALT", "test")
+	defer os.Unsetenv("IP_HASH_SALT")
+
+	return NewHandler(redirectStore{shortURLs: shortURLs}, generator)
+}
+
+// requestShortURL sends a GET request for the short URL, or a POST request with the password form when form isn't nil,
+// and returns the response with the statusCode of its JSON
+func requestShortURL(h *Handler, path string, form url.Values) (*httptest.ResponseRecorder, string) {
+	return requestShortURLFrom(h, "203.0.113.7:1234", path, form)
+}
+
+// requestShortURLFrom sends the request of requestShortURL from the remote address
+func requestShortURLFrom(h *Handler, remoteAddr string, path string, form url.Values) (*httptest.ResponseRecorder, string) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.NoRoute(h.RedirectShortURL)
+
+	request := httptest.NewRequest(http.MethodGet, path, nil)
+	if form != nil {
+		request = httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	request.Header.Set("Accept", gin.MIMEJSON)
+	request.RemoteAddr = remoteAddr
+	recorder := httptest.NewRecorder()
+	r.ServeHTTP(recorder, request)
+
+	var body struct {
+		StatusCode string `json:"statusCode"`
+	}
+	json.Unmarshal(recorder.Body.Bytes(), &body)
+
+	return recorder, body.StatusCode
+}
+
+func TestExpiredShortURLsAreGone(t *testing.T) {
+	h := newRedirectHandler(t, map[string]fakeShortURL{
+		"expired": {shortenedURL: store.ShortenedURL{LongURL: "https://example.com"}, statusCode: "EXPIRED_SHORTENEDURL"},
+	})
+
+	response, statusCode := requestShortURL(h, "/expired", nil)
+	if response.Code != http.StatusGone || statusCode != "EXPIRED_SHORTENEDURL" {
+		t.Errorf("got %d %s, want 410 EXPIRED_SHORTENEDURL", response.Code, statusCode)
+	}
+
+	os.Setenv("EXPIRED_LINK_FALLBACK_URL", "https://example.org/expired")
+	defer os.Unsetenv("EXPIRED_LINK_FALLBACK_URL")
+
+	response, _ = requestShortURL(h, "/expired", nil)
+	if location := response.Header().Get("Location"); response.Code != http.StatusFound || location != "https://example.org/expired" {
+		t.Errorf("got %d to %q with EXPIRED_LINK_FALLBACK_URL, want a 302 to the fallback URL", response.Code, location)
+	}
+}
+
+func TestUnknownShortURLsAreNotFound(t *testing.T) {
+	h := newRedirectHandler(t, map[string]fakeShortURL{
+		"known": {shortenedURL: store.ShortenedURL{LongURL: "https://example.com"}},
+	})
+
+	response, statusCode := requestShortURL(h, "/unknown", nil)
+	if response.Code != http.StatusNotFound || statusCode != "NON_EXISTING_SHORTENEDURL" {
+		t.Errorf("got %d %s, want 404 NON_EXISTING_SHORTENEDURL", response.Code, statusCode)
+	}
+
+	response, _ = requestShortURL(h, "/known", nil)
+	if location := response.Header().Get("Location"); response.Code != http.StatusFound || location != "https://example.com" {
+		t.Errorf("got %d to %q for a known short URL, want a 302 to its long URL", response.Code, location)
+	}
+}
+
+func TestExhaustedShortURLsAreGone(t *testing.T) {
+	h := newRedirectHandler(t, map[string]fakeShortURL{
+		"onetime": {shortenedURL: store.ShortenedURL{LongURL: "https://example.com", MaxVisits: 1, Visits: 1}, statusCode: "EXHAUSTED_SHORTENEDURL"},
+	})
+
+	response, statusCode := requestShortURL(h, "/onetime", nil)
+	if response.Code != http.StatusGone || statusCode != "EXHAUSTED_SHORTENEDURL" {
+		t.Errorf("got %d %s, want 410 EXHAUSTED_SHORTENEDURL", response.Code, statusCode)
+	}
+}
+
+func TestPasswordProtectedShortURLs(t *testing.T) {
+	h := newRedirectHandler(t, map[string]fakeShortURL{
+		"protected": {shortenedURL: store.ShortenedURL{LongURL: "https://example.com"}, password: "secret"},
+	})
+
+	tests := []struct {
+		name     string
+		form     url.Values
+		status   int
+		contains string
+	}{
+		{"without password", nil, http.StatusOK, `name="password"`},
+		{"wrong password", url.Values{"password": {"wrong"}}, http.StatusForbidden, "Wrong password"},
+		{"right password", url.Values{"password": {"secret"}}, http.StatusSeeOther, ""},
+	}
+
+	for _, test := range tests {
+		response, _ := requestShortURL(h, "/protected", test.form)
+		if response.Code != test.status || !strings.Contains(response.Body.String(), test.contains) {
+			t.Errorf("%s: got %d %q, want %d with %q", test.name, response.Code, response.Body.String(), test.status, test.contains)
+		}
+	}
+}
+
+func TestWrongPasswordsAreThrottledPerVisitor(t *testing.T) {
+	h := newRedirectHandler(t, map[string]fakeShortURL{
+		"protected": {shortenedURL: store.ShortenedURL{LongURL: "https://example.com"}, password: "secret"},
+		"open":      {shortenedURL: store.ShortenedURL{LongURL: "https://example.org"}},
+	})
+	wrong := url.Values{"password": {"wrong"}}
+	right := url.Values{"password": {"secret"}}
+
+	for i := 0; i < maxPasswordAttempts; i++ {
+		if response, _ := requestShortURL(h, "/protected", wrong); response.Code != http.StatusForbidden {
+			t.Fatalf("Wrong password %d got %d, want 403", i+1, response.Code)
+		}
+	}
+
+	// The visitor can't guess anymore, even with the right password, but short URLs without a password still work
+	if response, _ := requestShortURL(h, "/protected", right); response.Code != http.StatusTooManyRequests {
+		t.Errorf("The throttled visitor got %d with the right password, want 429", response.Code)
+	}
+	if response, _ := requestShortURL(h, "/open", right); response.Code != http.StatusSeeOther {
+		t.Errorf("The throttled visitor got %d posting to a short URL without a password, want 303", response.Code)
+	}
+
+	// Wrong passwords from many other visitors don't lock out a visitor who knows the password
+	for i := 0; i < 50; i++ {
+		requestShortURLFrom(h, fmt.Sprintf("198.51.100.%d:1234", i), "/protected", wrong)
+	}
+	if response, _ := requestShortURLFrom(h, "192.0.2.1:1234", "/protected", right); response.Code != http.StatusSeeOther {
+		t.Errorf("Another visitor got %d with the right password, want 303", response.Code)
+	}
+}
+
+func TestInactiveShortURLs(t *testing.T) {
+	activeFrom := time.Now()
+	h := newRedirectHandler(t, map[string]fakeShortURL{
+		"upcoming": {shortenedURL: store.ShortenedURL{LongURL: "https://example.com", ActiveFrom: &activeFrom},
+			statusCode: "NOT_YET_ACTIVE_SHORTENEDURL"},
+		// The store decides the short URL is no longer active, the handler doesn't need its ActiveUntil
+		"ended": {shortenedURL: store.ShortenedURL{LongURL: "https://example.com", ActiveFrom: &activeFrom},
+			statusCode: "NO_LONGER_ACTIVE_SHORTENEDURL"},
+	})
+
+	response, statusCode := requestShortURL(h, "/upcoming", nil)
+	if response.Code != http.StatusForbidden || statusCode != "NOT_YET_ACTIVE_SHORTENEDURL" {
+		t.Errorf("got %d %s, want 403 NOT_YET_ACTIVE_SHORTENEDURL", response.Code, statusCode)
+	}
+
+	response, statusCode = requestShortURL(h, "/ended", nil)
+	if response.Code != http.StatusGone || statusCode != "NO_LONGER_ACTIVE_SHORTENEDURL" {
+		t.Errorf("got %d %s, want 410 NO_LONGER_ACTIVE_SHORTENEDURL", response.Code, statusCode)
+	}
+}
@@ -0,0 +1,83 @@
+package handler
+
+import (
+	"bytes"
+	"html/template"
+
+	"github.com/gin-gonic/gin"
+)
+
+// passwordFormTemplate is the page that asks for the password of a protected short URL, it posts to the short URL itself
+var passwordFormTemplate = template.Must(template.New("passwordForm").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+	<meta charset="utf-8">
+	<meta name="viewport" content="width=device-width, initial-scale=1">
+	<title>Password required</title>
+</head>
+<body>
+	<form method="POST">
+		<h1>This short URL is password protected</h1>
+		{{if .}}<p role="alert">{{.}}</p>{{end}}
+		<label for="password">Password</label>
+		<input id="password" name="password" type="password" required autofocus>
+		<button type="submit">Continue</button>
+	</form>
+</body>
+</html>
+`))
+
+// messagePageTemplate is a page that tells visitors why a short URL doesn't redirect
+var messagePageTemplate = template.Must(template.New("messagePage").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+	<meta charset="utf-8">
+	<meta name="viewport" content="width=device-width, initial-scale=1">
+	<title>{{.Title}}</title>
+</head>
+<body>
+	<h1>{{.Title}}</h1>
+	<p>{{.Message}}</p>
+</body>
+</html>
+`))
+
+type messagePage struct {
+	Title   string
+	Message string
+}
+
+// passwordForm responds with the password form and an optional error message
+func passwordForm(c *gin.Context, status int, errorMessage string) {
+	renderPage(c, status, passwordFormTemplate, errorMessage)
+}
+
+// renderMessagePage responds with a page that shows the title and the message
+func renderMessagePage(c *gin.Context, status int, title string, message string) {
+	renderPage(c, status, messagePageTemplate, messagePage{Title: title, Message: message})
+}
+
+// respondUnavailable responds with a page for browsers and with JSON for API clients, based on the Accept header
+func respondUnavailable(c *gin.Context, status int, title string, message string, statusCode string) {
+	if c.NegotiateFormat(gin.MIMEJSON, gin.MIMEHTML) == gin.MIMEHTML {
+		renderMessagePage(c, status, title, message)
+		return
+	}
+
+	response := gin.H{"message": message}
+	if statusCode != "" {
+		response["statusCode"] = statusCode
+	}
+	c.JSON(status, response)
+}
+
+func renderPage(c *gin.Context, status int, pageTemplate *template.Template, data interface{}) {
+	var page bytes.Buffer
+	if err := pageTemplate.Execute(&page, data); err != nil {
+		c.String(500, "Failed to render the page")
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.Data(status, "text/html; charset=utf-8", page.Bytes())
+}
@@ -0,0 +1,94 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/devlaminckduncan/url-shortener/analytics"
+	"github.com/devlaminckduncan/url-shortener/store"
+	"github.com/gin-gonic/gin"
+)
+
+// permanentRedirectMaxAge is how long clients may cache a permanent redirect, it's kept short enough that changing the
+// long URL still reaches every visitor within a day
+const permanentRedirectMaxAge = 24 * 60 * 60
+
+const (
+	maxHeaderTextLength     = 1024
+	maxAcceptLanguageLength = 255
+)
+
+// isRedirectType checks whether the status code can be used as the redirect type of a short URL
+func isRedirectType(statusCode int) bool {
+	return statusCode == http.StatusMovedPermanently || statusCode == http.StatusFound ||
+		statusCode == http.StatusTemporaryRedirect || statusCode == http.StatusPermanentRedirect
+}
+
+// defaultRedirectTypeFromEnv returns the DEFAULT_REDIRECT_TYPE environment variable, 302 when it isn't set
+func defaultRedirectTypeFromEnv() int {
+	value := os.Getenv("DEFAULT_REDIRECT_TYPE")
+	if value == "" {
+		return http.StatusFound
+	}
+
+	redirectType, err := strconv.Atoi(value)
+	if err != nil || !isRedirectType(redirectType) {
+		panic(fmt.Sprintf("Invalid DEFAULT_REDIRECT_TYPE %q, use 301, 302, 307 or 308", value))
+	}
+
+	return redirectType
+}
+
+// redirectLongURL redirects to the long URL with the redirect type of the ShortenedURL and the matching Cache-Control
+// header, temporary redirects and short URLs with visit restrictions aren't cached so every visit reaches the server
+func (h *Handler) redirectLongURL(c *gin.Context, shortenedURL store.ShortenedURL) {
+	redirectType := shortenedURL.RedirectType
+	if redirectType == 0 {
+		redirectType = h.defaultRedirectType
+	}
+	// After the password form the browser has to GET the long URL, a 307 or 308 would post the password to it
+	if c.Request.Method == http.MethodPost {
+		redirectType = http.StatusSeeOther
+	}
+
+	if (redirectType == http.StatusMovedPermanently || redirectType == http.StatusPermanentRedirect) && !shortenedURL.HasVisitRestrictions() {
+		c.Header("Cache-Control", fmt.Sprintf("public, max-age=%d", permanentRedirectMaxAge))
+	} else {
+		c.Header("Cache-Control", "no-store")
+	}
+
+	c.Redirect(redirectType, shortenedURL.LongURL)
+}
+
+// visitFromRequest returns the ShortenedURLVisitsHistory with the details of the visitor that the request contains
+func (h *Handler) visitFromRequest(c *gin.Context) store.ShortenedURLVisitsHistory {
+	userAgent := c.Request.UserAgent()
+	parsedUserAgent := analytics.ParseUserAgent(userAgent)
+
+	return store.ShortenedURLVisitsHistory{
+		Referrer:       truncate(c.Request.Referer(), maxHeaderTextLength),
+		UserAgent:      truncate(userAgent, maxHeaderTextLength),
+		Browser:        parsedUserAgent.Browser,
+		OS:             parsedUserAgent.OS,
+		DeviceClass:    parsedUserAgent.DeviceClass,
+		AcceptLanguage: truncate(c.GetHeader("Accept-Language"), maxAcceptLanguageLength),
+		IPHash:         analytics.HashIP(c.ClientIP(), h.ipHashSalt),
+	}
+}
+
+// truncate cuts the value off at the maximum length in bytes without splitting a UTF-8 character
+func truncate(value string, maxLength int) string {
+	if len(value) <= maxLength {
+		return value
+	}
+
+	value = value[:maxLength]
+	for len(value) > 0 && !utf8.ValidString(value) {
+		value = value[:len(value)-1]
+	}
+
+	return value
+}
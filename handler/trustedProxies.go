@@ -0,0 +1,89 @@
+package handler
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TrustedProxiesFromEnv returns the IPs and CIDR ranges of the reverse proxies in front of the application from the
+// comma separated TRUSTED_PROXIES environment variable, without it no proxy is trusted
+func TrustedProxiesFromEnv() []*net.IPNet {
+	value := os.Getenv("TRUSTED_PROXIES")
+	if value == "" {
+		return nil
+	}
+
+	var trustedProxies []*net.IPNet
+	for _, proxy := range strings.Split(value, ",") {
+		proxy = strings.TrimSpace(proxy)
+		if !strings.Contains(proxy, "/") {
+			if ip := net.ParseIP(proxy); ip != nil && ip.To4() != nil {
+				proxy += "/32"
+			} else {
+				proxy += "/128"
+			}
+		}
+
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			panic(fmt.Sprintf("Invalid TRUSTED_PROXIES %q, use IPs or CIDR ranges separated by commas", value))
+		}
+
+		trustedProxies = append(trustedProxies, network)
+	}
+
+	return trustedProxies
+}
+
+// TrustForwardedHeaders makes ClientIP only believe the forwarded headers of the trusted proxies. Gin uses the first IP
+// of X-Forwarded-For, which the client can choose, so the header is replaced by the last IP that wasn't added by a
+// trusted proxy. Requests that didn't come from a trusted proxy lose their forwarded headers.
+func TrustForwardedHeaders(trustedProxies []*net.IPNet) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		remoteIP, _, err := net.SplitHostPort(strings.TrimSpace(c.Request.RemoteAddr))
+		if err != nil || !isTrustedProxy(remoteIP, trustedProxies) {
+			c.Request.Header.Del("X-Forwarded-For")
+			c.Request.Header.Del("X-Real-Ip")
+			c.Next()
+			return
+		}
+
+		forwardedFor := c.Request.Header.Get("X-Forwarded-For")
+		if forwardedFor != "" {
+			ips := strings.Split(forwardedFor, ",")
+
+			// Every proxy appends the IP it received the request from, so the IPs are trusted from the right
+			clientIP := strings.TrimSpace(ips[0])
+			for i := len(ips) - 1; i >= 0; i-- {
+				ip := strings.TrimSpace(ips[i])
+				if !isTrustedProxy(ip, trustedProxies) {
+					clientIP = ip
+					break
+				}
+			}
+
+			c.Request.Header.Set("X-Forwarded-For", clientIP)
+		}
+
+		c.Next()
+	}
+}
+
+func isTrustedProxy(ip string, trustedProxies []*net.IPNet) bool {
+	parsedIP := net.ParseIP(ip)
+	if parsedIP == nil {
+		return false
+	}
+
+	for _, network := range trustedProxies {
+		if network.Contains(parsedIP) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,63 @@
+package handler
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// clientIPWithProxies returns the ClientIP that a request from the remote address with the X-Forwarded-For header gets
+func clientIPWithProxies(trustedProxies []*net.IPNet, remoteAddr string, forwardedFor string) string {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.ForwardedByClientIP = len(trustedProxies) > 0
+	if r.ForwardedByClientIP {
+		r.Use(TrustForwardedHeaders(trustedProxies))
+	}
+
+	var clientIP string
+	r.GET("/", func(c *gin.Context) {
+		clientIP = c.ClientIP()
+	})
+
+	request := httptest.NewRequest(http.MethodGet, "/", nil)
+	request.RemoteAddr = remoteAddr
+	if forwardedFor != "" {
+		request.Header.Set("X-Forwarded-For", forwardedFor)
+	}
+	r.ServeHTTP(httptest.NewRecorder(), request)
+
+	return clientIP
+}
+
+func TestClientIPOnlyTrustsForwardedHeadersOfTrustedProxies(t *testing.T) {
+	os.Setenv("TRUSTED_PROXIES", "10.0.0.0/8, 192.168.1.1")
+	defer os.Unsetenv("TRUSTED_PROXIES")
+	trustedProxies := TrustedProxiesFromEnv()
+
+	tests := []struct {
+		name           string
+		trustedProxies []*net.IPNet
+		remoteAddr     string
+		forwardedFor   string
+		want           string
+	}{
+		{"no trusted proxies", nil, "203.0.113.7:1234", "198.51.100.1", "203.0.113.7"},
+		{"untrusted remote", trustedProxies, "203.0.113.7:1234", "198.51.100.1", "203.0.113.7"},
+		{"trusted proxy", trustedProxies, "10.1.2.3:1234", "198.51.100.1", "198.51.100.1"},
+		{"spoofed first IP", trustedProxies, "10.1.2.3:1234", "1.2.3.4, 198.51.100.1", "198.51.100.1"},
+		{"chain of proxies", trustedProxies, "10.1.2.3:1234", "1.2.3.4, 198.51.100.1, 192.168.1.1", "198.51.100.1"},
+		{"trusted proxy without header", trustedProxies, "10.1.2.3:1234", "", "10.1.2.3"},
+	}
+
+	for _, test := range tests {
+		got := clientIPWithProxies(test.trustedProxies, test.remoteAddr, test.forwardedFor)
+		if got != test.want {
+			t.Errorf("%s: ClientIP() = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
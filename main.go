@@ -1,10 +1,17 @@
 package main
 
 import (
+	"context"
+	"expvar"
 	"fmt"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/devlaminckduncan/url-shortener/handler"
+	"github.com/devlaminckduncan/url-shortener/shortener"
 	"github.com/devlaminckduncan/url-shortener/store"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
@@ -14,8 +21,36 @@ import (
 func main() {
 	godotenv.Load() // load environment variables
 
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		err := store.RunMigrateCommand(os.Args[2:])
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	urlStore := store.InitializeStore()
+	store.StartExpiredLinksSweeper(urlStore)
+	generator := shortener.NewGeneratorFromEnv(func() (uint64, error) {
+		value, statusCode, err := urlStore.NextShortURLCounter()
+		if err == nil && statusCode != "OK" {
+			err = fmt.Errorf("failed to increment the short URL counter: %s", statusCode)
+		}
+
+		return value, err
+	})
+	h := handler.NewHandler(urlStore, generator)
+
 	r := gin.Default()
 
+	// Gin believes X-Forwarded-For from anyone by default, which would let visitors choose their IP
+	trustedProxies := handler.TrustedProxiesFromEnv()
+	r.ForwardedByClientIP = len(trustedProxies) > 0
+	if r.ForwardedByClientIP {
+		r.Use(handler.TrustForwardedHeaders(trustedProxies))
+	}
+
 	corsConfig := cors.DefaultConfig()
 	corsConfig.AllowOrigins = []string{"http://localhost:8080", "https://gourlshortener-heroku.netlify.app", "https://gourlshortener-google.netlify.app", "https://gourlshortener-azure.netlify.app", "https://gourlshortener-aws.netlify.app"}
 	corsConfig.AddAllowHeaders("Origin", "Authorization")
@@ -25,60 +60,125 @@ func main() {
 	r.Use(cors.New(corsConfig))
 
 	r.POST("/api/short-urls", func(c *gin.Context) {
-		handler.CreateShortURL(c)
+		h.CreateShortURL(c)
 	})
 
 	r.PUT("/api/short-urls/:id", func(c *gin.Context) {
-		handler.UpdateShortURL(c)
+		h.UpdateShortURL(c)
 	})
 
 	r.DELETE("/api/short-urls/:id", func(c *gin.Context) {
-		handler.DeleteShortURL(c)
+		h.DeleteShortURL(c)
 	})
 
-	r.GET("/api/short-urls/:userID", func(c *gin.Context) {
-		handler.GetUserShortenedURLs(c)
+	// :id is the user ID here, Gin needs the same wildcard name for both routes
+	r.GET("/api/short-urls/:id", func(c *gin.Context) {
+		h.GetUserShortenedURLs(c)
+	})
+
+	r.GET("/api/short-urls/:id/stats", func(c *gin.Context) {
+		h.GetShortURLStats(c)
 	})
 
 	r.POST("/api/signup", func(c *gin.Context) {
-		handler.CreateUser(c)
+		h.CreateUser(c)
 	})
 
 	r.POST("/api/login", func(c *gin.Context) {
-		handler.CheckUserLogin(c)
+		h.CheckUserLogin(c)
+	})
+
+	r.POST("/api/logout", func(c *gin.Context) {
+		h.Logout(c)
+	})
+
+	r.POST("/api/token/refresh", func(c *gin.Context) {
+		h.RefreshToken(c)
 	})
 
 	r.GET("/api/user/:userID", func(c *gin.Context) {
-		handler.GetUser(c)
+		h.GetUser(c)
+	})
+
+	r.GET("/api/user/:userID/sessions", func(c *gin.Context) {
+		h.GetUserSessions(c)
+	})
+
+	r.DELETE("/api/user/:userID/sessions/:sessionID", func(c *gin.Context) {
+		h.DeleteUserSession(c)
+	})
+
+	r.POST("/api/user/:userID/api-keys", func(c *gin.Context) {
+		h.CreateAPIKey(c)
+	})
+
+	r.GET("/api/user/:userID/api-keys", func(c *gin.Context) {
+		h.GetUserAPIKeys(c)
+	})
+
+	r.DELETE("/api/user/:userID/api-keys/:apiKeyID", func(c *gin.Context) {
+		h.DeleteAPIKey(c)
 	})
 
 	r.PUT("/api/user/:userID", func(c *gin.Context) {
-		handler.UpdateUser(c)
+		h.UpdateUser(c)
 	})
 
 	r.DELETE("/api/user/:userID", func(c *gin.Context) {
-		handler.DeleteUser(c)
+		h.DeleteUser(c)
 	})
 
+	// Exposes the visit queue and short URL cache counters for monitoring
+	if os.Getenv("ENABLE_METRICS") == "true" {
+		r.GET("/debug/vars", gin.WrapH(expvar.Handler()))
+	}
+
 	r.NoRoute(func(c *gin.Context) {
 		shortURL := c.Request.URL.Path[1:]
 
-		if len(shortURL) == 8 {
-			handler.RedirectShortURL(c)
+		// Generated short URLs, also the ones of an earlier length or alphabet, have the shape of an alias, so the other
+		// paths can't exist. Unknown short URLs are cached as missing, so paths like /wp-admin don't reach the database
+		// on every request.
+		if shortener.ValidateAlias(shortURL) == "OK" {
+			h.RedirectShortURL(c)
 		} else {
 			handler.NotFound(c)
 		}
 	})
 
-	store.InitializeStore()
-
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "9001"
 	}
 
-	err := r.Run(":" + port)
+	server := &http.Server{
+		Addr:    ":" + port,
+		Handler: r,
+	}
+
+	go func() {
+		err := server.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			panic(fmt.Sprintf("Failed to start the web server:\n%v", err))
+		}
+	}()
+
+	// Finish the running requests and save the queued visits before stopping
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+
+	fmt.Println("Shutting down the web server...")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	err := server.Shutdown(ctx)
+	if err != nil {
+		fmt.Println("Failed to shut down the web server gracefully:\n" + err.Error())
+	}
+
+	err = urlStore.Close()
 	if err != nil {
-		panic(fmt.Sprintf("Failed to start the web server:\n%v", err))
+		fmt.Println("Failed to close the database:\n" + err.Error())
 	}
 }
@@ -0,0 +1,43 @@
+package shortener
+
+import (
+	"regexp"
+	"strings"
+)
+
+const (
+	minAliasLength = 3
+	maxAliasLength = 50
+)
+
+var aliasPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// AliasRules describes which aliases are valid
+const AliasRules = "3 to 50 letters, digits, dashes or underscores that aren't a reserved word like api, signup or login"
+
+// reservedAliases can't be used as an alias because they're (or could become) paths of the application itself
+var reservedAliases = map[string]bool{
+	"api":    true,
+	"signup": true,
+	"login":  true,
+	"logout": true,
+	"admin":  true,
+	"debug":  true,
+}
+
+// ValidateAlias checks whether a custom alias can be used as a short URL and returns a status code
+func ValidateAlias(alias string) string {
+	if len(alias) < minAliasLength || len(alias) > maxAliasLength {
+		return "INVALID_ALIAS_LENGTH"
+	}
+
+	if !aliasPattern.MatchString(alias) {
+		return "INVALID_ALIAS_CHARACTERS"
+	}
+
+	if reservedAliases[strings.ToLower(alias)] {
+		return "RESERVED_ALIAS"
+	}
+
+	return "OK"
+}
@@ -0,0 +1,48 @@
+package shortener
+
+import (
+	"fmt"
+	"math/big"
+)
+
+// alphabets contains the characters that can be used in generated short URLs
+var alphabets = map[string]string{
+	// base58 is the Bitcoin Base58 alphabet, it leaves out 0, O, I and l because they look alike
+	"base58": "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz",
+	"base62": "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz",
+	// lowercase is meant for printed short URLs that people type over, so it also leaves out 0, o, 1 and l
+	"lowercase": "23456789abcdefghijkmnpqrstuvwxyz",
+}
+
+func getAlphabet(name string) (string, error) {
+	if name == "" {
+		name = "base58"
+	}
+
+	alphabet, ok := alphabets[name]
+	if !ok {
+		return "", fmt.Errorf("unknown short URL alphabet %q, use base58, base62 or lowercase", name)
+	}
+
+	return alphabet, nil
+}
+
+// encodeNumber writes the number in the base of the alphabet using the characters of the alphabet as digits
+func encodeNumber(number *big.Int, alphabet string) string {
+	base := big.NewInt(int64(len(alphabet)))
+	remaining := new(big.Int).Set(number)
+	digit := new(big.Int)
+
+	var encoded []byte
+	for remaining.Sign() > 0 {
+		remaining.DivMod(remaining, base, digit)
+		encoded = append(encoded, alphabet[digit.Int64()])
+	}
+
+	// The digits were added from least to most significant
+	for i, j := 0, len(encoded)-1; i < j; i, j = i+1, j-1 {
+		encoded[i], encoded[j] = encoded[j], encoded[i]
+	}
+
+	return string(encoded)
+}
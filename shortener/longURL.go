@@ -0,0 +1,14 @@
+package shortener
+
+import "net/url"
+
+// ValidateLongURL checks whether a long URL is an absolute http or https URL that can be redirected to and returns a
+// status code
+func ValidateLongURL(longURL string) string {
+	parsedURL, err := url.Parse(longURL)
+	if err != nil || (parsedURL.Scheme != "http" && parsedURL.Scheme != "https") || parsedURL.Host == "" {
+		return "INVALID_LONG_URL"
+	}
+
+	return "OK"
+}
@@ -0,0 +1,22 @@
+package shortener
+
+import "testing"
+
+func TestValidateLongURL(t *testing.T) {
+	tests := map[string]string{
+		"https://example.com/page?query=1": "OK",
+		"http://example.com":               "OK",
+		"javascript:alert(1)":              "INVALID_LONG_URL",
+		"data:text/html,<script></script>": "INVALID_LONG_URL",
+		"ftp://example.com/file":           "INVALID_LONG_URL",
+		"//example.com":                    "INVALID_LONG_URL",
+		"https://":                         "INVALID_LONG_URL",
+		"example.com":                      "INVALID_LONG_URL",
+	}
+
+	for longURL, want := range tests {
+		if got := ValidateLongURL(longURL); got != want {
+			t.Errorf("ValidateLongURL(%q) = %s, want %s", longURL, got, want)
+		}
+	}
+}
@@ -2,13 +2,26 @@ package shortener
 
 import (
 	"crypto/sha256"
+	"errors"
 	"fmt"
 	"math/big"
 	"os"
+	"strconv"
+	"strings"
+)
 
-	"github.com/itchyny/base58-go"
+const (
+	defaultShortURLLength = 8
+	minShortURLLength     = 4
+	maxShortURLLength     = 32
+	// minCounterCodes is how many short URLs the counter strategy needs at least before its sequence starts over and
+	// every new short URL is taken
+	minCounterCodes = 10000000
 )
 
+// MaxAttempts is how many short URLs the Generator generates for a long URL before giving up because they were all taken
+const MaxAttempts = 5
+
 func generateSHA256Bytes(input string) []byte {
 	algorithm := sha256.New()
 	algorithm.Write([]byte(input))
@@ -16,24 +29,131 @@ func generateSHA256Bytes(input string) []byte {
 	return algorithm.Sum(nil)
 }
 
-func generateBase58String(bytes []byte) string {
-	encoding := base58.BitcoinEncoding
-	encoded, err := encoding.Encode(bytes)
+// GenerateShortURL returns an 8 character long Base58 string using an SHA256 hash based on a long URL and a user's ID
+func GenerateShortURL(longURL string, userID string) string {
+	generator, _ := NewGenerator("hash", "base58", defaultShortURLLength, nil)
+	shortURL, _ := generator.Generate(longURL, userID, 0)
+
+	return shortURL
+}
 
+// Generator generates short URLs with a Strategy
+type Generator struct {
+	strategy Strategy
+	alphabet string
+	length   int
+}
+
+// NewGenerator returns a Generator that uses the strategy with the given name (hash, random or counter) to generate
+// short URLs with the given length and alphabet (base58, base62 or lowercase), the counter strategy needs a Counter
+func NewGenerator(strategyName string, alphabetName string, length int, counter Counter) (*Generator, error) {
+	alphabet, err := getAlphabet(alphabetName)
 	if err != nil {
-		fmt.Println(err.Error())
-		os.Exit(1)
+		return nil, err
 	}
 
-	return string(encoded)
+	if length < minShortURLLength || length > maxShortURLLength {
+		return nil, fmt.Errorf("the short URL length must be between %d and %d, got %d", minShortURLLength, maxShortURLLength, length)
+	}
+
+	generator := &Generator{
+		alphabet: alphabet,
+		length:   length,
+	}
+
+	switch strategyName {
+	case "", "hash":
+		generator.strategy = hashStrategy{alphabet: alphabet, length: length}
+	case "random":
+		generator.strategy = randomStrategy{alphabet: alphabet}
+	case "counter":
+		if counter == nil {
+			return nil, errors.New("the counter strategy needs a counter")
+		}
+
+		codes := counterCodes(alphabet, length)
+		if codes.Cmp(big.NewInt(minCounterCodes)) < 0 {
+			return nil, fmt.Errorf("the counter strategy can only generate %v short URLs with length %d, use a longer length", codes, length)
+		}
+
+		generator.strategy = newCounterStrategy(alphabet, length, counter)
+	default:
+		return nil, fmt.Errorf("unknown short URL strategy %q, use hash, random or counter", strategyName)
+	}
+
+	return generator, nil
 }
 
-// GenerateShortURL returns an 8 character long Base58 string using an SHA256 hash based on a long URL and a user's ID
-func GenerateShortURL(longURL string, userID string) string {
-	urlHashBytes := generateSHA256Bytes(longURL + userID)
-	generatedNumber := new(big.Int).SetBytes(urlHashBytes).Uint64()
+// NewGeneratorFromEnv returns a Generator configured with SHORT_URL_STRATEGY, SHORT_URL_ALPHABET and SHORT_URL_LENGTH,
+// by default it generates 8 character long Base58 short URLs with the hash strategy. The counter strategy uses the
+// given Counter.
+func NewGeneratorFromEnv(counter Counter) *Generator {
+	length := defaultShortURLLength
+	if lengthString := os.Getenv("SHORT_URL_LENGTH"); lengthString != "" {
+		var err error
+		length, err = strconv.Atoi(lengthString)
+		if err != nil {
+			panic(fmt.Sprintf("Invalid SHORT_URL_LENGTH %q:\n%v", lengthString, err))
+		}
+	}
+
+	generator, err := NewGenerator(os.Getenv("SHORT_URL_STRATEGY"), os.Getenv("SHORT_URL_ALPHABET"), length, counter)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	return generator
+}
 
-	result := generateBase58String([]byte(fmt.Sprintf("%d", generatedNumber)))
+// Generate returns a short URL candidate for the given attempt (starting at 0).
+// Every second retry makes the short URL one character longer to make another collision less likely.
+func (g *Generator) Generate(longURL string, userID string, attempt int) (string, error) {
+	length := g.length + attempt/2
+
+	shortURL, err := g.strategy.Generate(longURL, userID, attempt)
+	if err != nil {
+		return "", err
+	}
+	if len(shortURL) > length {
+		shortURL = shortURL[:length]
+	}
+
+	return shortURL, nil
+}
+
+// IsShortURL checks whether the value could be a short URL generated with the current configuration, in any case when
+// the alphabet only has lowercase letters
+func (g *Generator) IsShortURL(value string) bool {
+	if len(value) < g.length || len(value) > g.length+(MaxAttempts-1)/2 {
+		return false
+	}
+
+	value = g.NormalizeShortURL(value)
+	for _, character := range value {
+		if !strings.ContainsRune(g.alphabet, character) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// NormalizeShortURL lowercases the value when the alphabet only has lowercase letters, so the short URLs that people
+// type over from print work in any case
+func (g *Generator) NormalizeShortURL(value string) string {
+	if strings.ToLower(g.alphabet) == g.alphabet {
+		return strings.ToLower(value)
+	}
+
+	return value
+}
+
+// ShortURLCandidates returns the short URLs that the value can refer to, the value itself because aliases keep their
+// case, followed by the normalized short URL when the value is a generated short URL in another case
+func (g *Generator) ShortURLCandidates(value string) []string {
+	if normalized := g.NormalizeShortURL(value); normalized != value && g.IsShortURL(value) {
+		return []string{value, normalized}
+	}
 
-	return result[:8]
+	return []string{value}
 }
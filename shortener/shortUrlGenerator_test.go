@@ -0,0 +1,116 @@
+package shortener
+
+import (
+	"strings"
+	"testing"
+)
+
+// newTestCounter returns a Counter that starts at the given value like a ShortURLCounter in the database would
+func newTestCounter(start uint64) Counter {
+	value := start
+	return func() (uint64, error) {
+		value++
+		return value, nil
+	}
+}
+
+func TestCounterStrategyGeneratesDifferentShortURLsOfTheLength(t *testing.T) {
+	configs := []struct {
+		alphabet string
+		length   int
+	}{
+		{"base58", 5},
+		{"base62", 4},
+		{"lowercase", 6},
+	}
+
+	for _, config := range configs {
+		// The counter also starts over after every short URL of the length was used
+		for _, start := range []uint64{0, 1790000000, ^uint64(0) - 10} {
+			generator, err := NewGenerator("counter", config.alphabet, config.length, newTestCounter(start))
+			if err != nil {
+				t.Fatalf("NewGenerator(counter, %s, %d) returned %v", config.alphabet, config.length, err)
+			}
+
+			generated := make(map[string]bool)
+			for i := 0; i < 100; i++ {
+				shortURL, err := generator.Generate("https://example.com", "user", 0)
+				if err != nil {
+					t.Fatalf("Generate returned %v", err)
+				}
+				if len(shortURL) != config.length {
+					t.Errorf("%s short URL %q has length %d, want %d", config.alphabet, shortURL, len(shortURL), config.length)
+				}
+				if generated[shortURL] {
+					t.Fatalf("%s short URL %q was generated twice starting at %d", config.alphabet, shortURL, start)
+				}
+				generated[shortURL] = true
+			}
+		}
+	}
+}
+
+func TestCounterStrategyRejectsTooFewShortURLs(t *testing.T) {
+	_, err := NewGenerator("counter", "lowercase", 4, newTestCounter(0))
+	if err == nil {
+		t.Error("NewGenerator(counter, lowercase, 4) accepted a length with about a million short URLs")
+	}
+
+	_, err = NewGenerator("counter", "base58", 8, nil)
+	if err == nil {
+		t.Error("NewGenerator(counter, base58, 8) accepted a missing counter")
+	}
+}
+
+func TestLowercaseShortURLsAreCaseInsensitive(t *testing.T) {
+	generator, err := NewGenerator("random", "lowercase", 8, nil)
+	if err != nil {
+		t.Fatalf("NewGenerator returned %v", err)
+	}
+
+	if !generator.IsShortURL("ABCD2345") {
+		t.Error("IsShortURL(ABCD2345) is false with the lowercase alphabet")
+	}
+	if normalized := generator.NormalizeShortURL("ABCD2345"); normalized != "abcd2345" {
+		t.Errorf("NormalizeShortURL(ABCD2345) = %q, want abcd2345", normalized)
+	}
+
+	generator, err = NewGenerator("random", "base58", 8, nil)
+	if err != nil {
+		t.Fatalf("NewGenerator returned %v", err)
+	}
+
+	if normalized := generator.NormalizeShortURL("ABCD2345"); normalized != "ABCD2345" {
+		t.Errorf("NormalizeShortURL(ABCD2345) = %q with base58, want it unchanged", normalized)
+	}
+}
+
+func TestShortURLCandidates(t *testing.T) {
+	lowercase, err := NewGenerator("random", "lowercase", 8, nil)
+	if err != nil {
+		t.Fatalf("NewGenerator returned %v", err)
+	}
+	base58, err := NewGenerator("random", "base58", 8, nil)
+	if err != nil {
+		t.Fatalf("NewGenerator returned %v", err)
+	}
+
+	tests := []struct {
+		generator *Generator
+		value     string
+		want      []string
+	}{
+		{lowercase, "ABCD2345", []string{"ABCD2345", "abcd2345"}},
+		{lowercase, "abcd2345", []string{"abcd2345"}},
+		// Aliases that can't be generated keep their case
+		{lowercase, "MyLink", []string{"MyLink"}},
+		{base58, "ABCD2345", []string{"ABCD2345"}},
+	}
+
+	for _, test := range tests {
+		got := test.generator.ShortURLCandidates(test.value)
+		if strings.Join(got, ",") != strings.Join(test.want, ",") {
+			t.Errorf("ShortURLCandidates(%s) = %v, want %v", test.value, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package shortener
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+)
+
+// Strategy generates short URL candidates of at least the configured length, attempt starts at 0 and increases every
+// time a candidate was already taken
+type Strategy interface {
+	Generate(longURL string, userID string, attempt int) (string, error)
+}
+
+// Counter returns the next value of a counter that survives restarts and is shared by every replica
+type Counter func() (uint64, error)
+
+// hashStrategy derives the short URL from an SHA256 hash of the long URL and the user's ID, so the same user always
+// gets the same short URL for the same long URL. Retries add the attempt to the hash as a salt.
+type hashStrategy struct {
+	alphabet string
+	length   int
+}
+
+func (s hashStrategy) Generate(longURL string, userID string, attempt int) (string, error) {
+	if attempt == 0 {
+		urlHashBytes := generateSHA256Bytes(longURL + userID)
+		generatedNumber := new(big.Int).SetBytes(urlHashBytes).Uint64()
+
+		shortURL := encodeNumber(new(big.Int).SetUint64(generatedNumber), s.alphabet)
+		if len(shortURL) >= s.length {
+			return shortURL, nil
+		}
+	}
+
+	// Use the whole hash when the first 64 bits are too short for the length or for a retry
+	urlHashBytes := generateSHA256Bytes(fmt.Sprintf("%s%s#%d", longURL, userID, attempt))
+
+	return encodeNumber(new(big.Int).SetBytes(urlHashBytes), s.alphabet), nil
+}
+
+// randomStrategy generates a cryptographically random short URL
+type randomStrategy struct {
+	alphabet string
+}
+
+func (s randomStrategy) Generate(longURL string, userID string, attempt int) (string, error) {
+	randomBytes := make([]byte, 32)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", fmt.Errorf("failed to read random bytes: %w", err)
+	}
+
+	return encodeNumber(new(big.Int).SetBytes(randomBytes), s.alphabet), nil
+}
+
+// counterStrategy encodes the values of a Counter, which makes the short URLs sequential and predictable. The Counter
+// is stored in the database so a restart or another replica continues the sequence instead of repeating it.
+type counterStrategy struct {
+	alphabet string
+	next     Counter
+	// offset is the smallest number with the configured length of digits and codes is how many numbers have that
+	// length, so every short URL has the same length and the sequence only starts over after all of them were used
+	offset *big.Int
+	codes  *big.Int
+}
+
+func newCounterStrategy(alphabet string, length int, next Counter) counterStrategy {
+	offset := new(big.Int).Exp(big.NewInt(int64(len(alphabet))), big.NewInt(int64(length-1)), nil)
+
+	return counterStrategy{alphabet: alphabet, next: next, offset: offset, codes: counterCodes(alphabet, length)}
+}
+
+// counterCodes returns how many short URLs of the length the counter strategy can generate with the alphabet
+func counterCodes(alphabet string, length int) *big.Int {
+	base := big.NewInt(int64(len(alphabet)))
+	allCodes := new(big.Int).Exp(base, big.NewInt(int64(length)), nil)
+	shorterCodes := new(big.Int).Exp(base, big.NewInt(int64(length-1)), nil)
+
+	return allCodes.Sub(allCodes, shorterCodes)
+}
+
+func (s counterStrategy) Generate(longURL string, userID string, attempt int) (string, error) {
+	counter, err := s.next()
+	if err != nil {
+		return "", err
+	}
+
+	value := new(big.Int).SetUint64(counter)
+	value.Mod(value, s.codes)
+
+	return encodeNumber(value.Add(value, s.offset), s.alphabet), nil
+}
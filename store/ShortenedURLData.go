@@ -1,7 +1,10 @@
 package store
 
-// ShortenedURLData contains a list of ShortenedURLs and analytics (a list of ShortenedURLVisitsHistory)
+// ShortenedURLData contains a list of ShortenedURLs and analytics (a list of the times of the visits), the details of
+// the visits are only available through the stats of a ShortenedURL
 type ShortenedURLData struct {
 	ShortenedURLObject ShortenedURL `json:"shortenedURL"`
 	Analytics          []string     `json:"analytics"`
+	RemainingVisits    *int         `json:"remainingVisits,omitempty"`
+	PasswordProtected  bool         `json:"passwordProtected"`
 }
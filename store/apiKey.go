@@ -0,0 +1,44 @@
+package store
+
+import "time"
+
+// The scopes limit what an APIKey can be used for, API keys can't manage the user's account
+const (
+	ScopeLinksRead     = "links:read"
+	ScopeLinksWrite    = "links:write"
+	ScopeAnalyticsRead = "analytics:read"
+)
+
+// APIKeyPrefix starts every API key so it can't be mistaken for an access token
+const APIKeyPrefix = "usk_"
+
+// APIKey contains the hash of a long-lived key that a user created for scripts, the key itself is only shown when it's
+// created
+type APIKey struct {
+	ID      string `json:"id" xorm:"pk not null unique"`
+	UserID  string `json:"-" xorm:"not null index"`
+	Name    string `json:"name" xorm:"not null"`
+	KeyHash string `json:"-" xorm:"not null unique"`
+	// Prefix is the start of the key so the user can tell their keys apart
+	Prefix     string     `json:"prefix" xorm:"not null"`
+	Scopes     []string   `json:"scopes" xorm:"text not null"`
+	CreatedAt  time.Time  `json:"createdAt" xorm:"not null default CURRENT_TIMESTAMP created"`
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty" xorm:"null"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty" xorm:"null"`
+}
+
+// HasScope checks whether the APIKey was given the scope
+func (apiKey APIKey) HasScope(scope string) bool {
+	for _, apiKeyScope := range apiKey.Scopes {
+		if apiKeyScope == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsExpired checks whether the APIKey expired
+func (apiKey APIKey) IsExpired(now time.Time) bool {
+	return apiKey.ExpiresAt != nil && !now.Before(*apiKey.ExpiresAt)
+}
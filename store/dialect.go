@@ -0,0 +1,35 @@
+package store
+
+import (
+	"fmt"
+	"os"
+
+	"xorm.io/xorm"
+)
+
+// dialect contains the database specific logic of a storage backend
+type dialect interface {
+	// openEngine opens the database and creates it if it doesn't exist yet, it also returns whether the database already existed
+	openEngine() (*xorm.Engine, bool, error)
+	// classifyError wraps the error in a DatabaseError when it's a duplicate key, a missing row or a missing column
+	classifyError(err error) error
+	// timeBucket returns the SQL expression that formats the start of the hour, day or week (starting on Monday) of the
+	// datetime column as "YYYY-MM-DD HH:MM:SS"
+	timeBucket(column string, interval string) string
+}
+
+// newDialect returns the dialect of the database driver selected with DATABASE_DRIVER, MySQL is used by default
+func newDialect() dialect {
+	databaseDriver := os.Getenv("DATABASE_DRIVER")
+
+	switch databaseDriver {
+	case "", "mysql":
+		return mysqlDialect{connectionString: os.Getenv("MYSQL_CONNECTION_STRING")}
+	case "sqlite3":
+		return sqliteDialect{path: os.Getenv("SQLITE_DATABASE_PATH")}
+	case "postgres":
+		return postgresDialect{connectionString: os.Getenv("POSTGRES_CONNECTION_STRING")}
+	default:
+		panic(fmt.Sprintf("Unsupported database driver %q", databaseDriver))
+	}
+}
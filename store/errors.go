@@ -0,0 +1,54 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+)
+
+var (
+	// ErrDuplicate is returned when a unique or primary key already exists in the database
+	ErrDuplicate = errors.New("duplicate key")
+	// ErrNotFound is returned when a query didn't find any rows
+	ErrNotFound = errors.New("record not found")
+	// ErrMissingColumn is returned when a query uses a column that doesn't exist in the database
+	ErrMissingColumn = errors.New("missing column")
+)
+
+// DatabaseError is a database error that was classified by the dialect of a storage backend
+type DatabaseError struct {
+	Kind error
+	Err  error
+}
+
+func (e *DatabaseError) Error() string {
+	return e.Kind.Error() + ": " + e.Err.Error()
+}
+
+// Unwrap returns the original database error
+func (e *DatabaseError) Unwrap() error {
+	return e.Err
+}
+
+// MarshalJSON marshals the original database error so API responses don't change
+func (e *DatabaseError) MarshalJSON() ([]byte, error) {
+	return json.Marshal(e.Err)
+}
+
+// Is makes errors.Is match the kind of the error, e.g. ErrDuplicate
+func (e *DatabaseError) Is(target error) bool {
+	return e.Kind == target
+}
+
+func newDatabaseError(kind error, err error) error {
+	return &DatabaseError{Kind: kind, Err: err}
+}
+
+// classifyCommonError classifies the errors that don't depend on the database driver
+func classifyCommonError(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return newDatabaseError(ErrNotFound, err)
+	}
+
+	return err
+}
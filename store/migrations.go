@@ -0,0 +1,334 @@
+package store
+
+import (
+	"time"
+
+	"xorm.io/xorm"
+)
+
+// The structs in this file are snapshots of the models at the time of a migration so that later changes to the
+// models don't change what an old migration does.
+
+type shortenedURLV1 struct {
+	ID        string    `xorm:"pk not null unique"`
+	Name      string    `xorm:"not null"`
+	CreatedAt time.Time `xorm:"not null default CURRENT_TIMESTAMP created"`
+	ShortURL  string    `xorm:"not null unique"`
+	LongURL   string    `xorm:"not null"`
+}
+
+type shortenedURLV2 struct {
+	ID         string     `xorm:"pk not null unique"`
+	Name       string     `xorm:"not null"`
+	CreatedAt  time.Time  `xorm:"not null default CURRENT_TIMESTAMP created"`
+	ShortURL   string     `xorm:"not null unique"`
+	LongURL    string     `xorm:"not null"`
+	ExpiresAt  *time.Time `xorm:"null"`
+	ArchivedAt *time.Time `xorm:"null"`
+}
+
+type shortenedURLV3 struct {
+	ID         string     `xorm:"pk not null unique"`
+	Name       string     `xorm:"not null"`
+	CreatedAt  time.Time  `xorm:"not null default CURRENT_TIMESTAMP created"`
+	ShortURL   string     `xorm:"not null unique"`
+	LongURL    string     `xorm:"not null"`
+	ExpiresAt  *time.Time `xorm:"null"`
+	ArchivedAt *time.Time `xorm:"null"`
+	MaxVisits  int        `xorm:"not null default 0"`
+	Visits     int        `xorm:"not null default 0"`
+}
+
+type shortenedURLV4 struct {
+	ID         string     `xorm:"pk not null unique"`
+	Name       string     `xorm:"not null"`
+	CreatedAt  time.Time  `xorm:"not null default CURRENT_TIMESTAMP created"`
+	ShortURL   string     `xorm:"not null unique"`
+	LongURL    string     `xorm:"not null"`
+	ExpiresAt  *time.Time `xorm:"null"`
+	ArchivedAt *time.Time `xorm:"null"`
+	MaxVisits  int        `xorm:"not null default 0"`
+	Visits     int        `xorm:"not null default 0"`
+	Password   string     `xorm:"null"`
+}
+
+type shortenedURLV5 struct {
+	ID          string     `xorm:"pk not null unique"`
+	Name        string     `xorm:"not null"`
+	CreatedAt   time.Time  `xorm:"not null default CURRENT_TIMESTAMP created"`
+	ShortURL    string     `xorm:"not null unique"`
+	LongURL     string     `xorm:"not null"`
+	ExpiresAt   *time.Time `xorm:"null"`
+	ArchivedAt  *time.Time `xorm:"null"`
+	MaxVisits   int        `xorm:"not null default 0"`
+	Visits      int        `xorm:"not null default 0"`
+	ActiveFrom  *time.Time `xorm:"null"`
+	ActiveUntil *time.Time `xorm:"null"`
+	Password    string     `xorm:"null"`
+}
+
+type shortenedURLV7 struct {
+	ID           string     `xorm:"pk not null unique"`
+	Name         string     `xorm:"not null"`
+	CreatedAt    time.Time  `xorm:"not null default CURRENT_TIMESTAMP created"`
+	ShortURL     string     `xorm:"not null unique"`
+	LongURL      string     `xorm:"not null"`
+	ExpiresAt    *time.Time `xorm:"null"`
+	ArchivedAt   *time.Time `xorm:"null"`
+	MaxVisits    int        `xorm:"not null default 0"`
+	Visits       int        `xorm:"not null default 0"`
+	ActiveFrom   *time.Time `xorm:"null"`
+	ActiveUntil  *time.Time `xorm:"null"`
+	RedirectType int        `xorm:"not null default 0"`
+	Password     string     `xorm:"null"`
+}
+
+type shortenedURLVisitsHistoryV1 struct {
+	ShortenedURLID string    `xorm:"not null"`
+	VisitedAt      time.Time `xorm:"not null default CURRENT_TIMESTAMP created"`
+}
+
+type shortenedURLRevisionV1 struct {
+	ID              string    `xorm:"pk not null unique"`
+	ShortenedURLID  string    `xorm:"not null index"`
+	PreviousLongURL string    `xorm:"not null"`
+	NewLongURL      string    `xorm:"not null"`
+	ChangedBy       string    `xorm:"not null"`
+	ChangedAt       time.Time `xorm:"not null default CURRENT_TIMESTAMP created"`
+}
+
+type shortenedURLVisitsHistoryV2 struct {
+	ShortenedURLID string    `xorm:"not null"`
+	VisitedAt      time.Time `xorm:"not null default CURRENT_TIMESTAMP created"`
+	Referrer       string    `xorm:"text null"`
+	UserAgent      string    `xorm:"text null"`
+	Browser        string    `xorm:"null"`
+	OS             string    `xorm:"null"`
+	DeviceClass    string    `xorm:"null"`
+	AcceptLanguage string    `xorm:"null"`
+	IPHash         string    `xorm:"null"`
+}
+
+type userShortenedURLV1 struct {
+	UserID         string `xorm:"not null"`
+	ShortenedURLID string `xorm:"not null"`
+}
+
+type userV1 struct {
+	ID        string `xorm:"pk not null unique"`
+	FirstName string `xorm:"not null"`
+	LastName  string `xorm:"not null"`
+	Username  string `xorm:"not null unique"`
+	Email     string `xorm:"not null unique"`
+	Password  string `xorm:"not null"`
+}
+
+type userTokenV1 struct {
+	UserID string `xorm:"not null"`
+	Token  []byte `xorm:"not null"`
+}
+
+type userTokenV2 struct {
+	UserID   string `xorm:"not null"`
+	Token    []byte `xorm:"not null"`
+	FamilyID string `xorm:"null"`
+}
+
+type userTokenV3 struct {
+	UserID     string     `xorm:"not null"`
+	Token      []byte     `xorm:"not null"`
+	FamilyID   string     `xorm:"null"`
+	CreatedAt  time.Time  `xorm:"null"`
+	LastUsedAt *time.Time `xorm:"null"`
+	UserAgent  string     `xorm:"text null"`
+	IP         string     `xorm:"null"`
+}
+
+type refreshTokenV1 struct {
+	ID        string     `xorm:"pk not null unique"`
+	FamilyID  string     `xorm:"not null index"`
+	UserID    string     `xorm:"not null index"`
+	TokenHash string     `xorm:"not null unique"`
+	CreatedAt time.Time  `xorm:"not null default CURRENT_TIMESTAMP created"`
+	ExpiresAt time.Time  `xorm:"not null"`
+	UsedAt    *time.Time `xorm:"null"`
+	RevokedAt *time.Time `xorm:"null"`
+}
+
+type apiKeyV1 struct {
+	ID         string     `xorm:"pk not null unique"`
+	UserID     string     `xorm:"not null index"`
+	Name       string     `xorm:"not null"`
+	KeyHash    string     `xorm:"not null unique"`
+	Prefix     string     `xorm:"not null"`
+	Scopes     []string   `xorm:"text not null"`
+	CreatedAt  time.Time  `xorm:"not null default CURRENT_TIMESTAMP created"`
+	ExpiresAt  *time.Time `xorm:"null"`
+	LastUsedAt *time.Time `xorm:"null"`
+}
+
+type shortURLCounterV1 struct {
+	ID        int   `xorm:"pk not null"`
+	LastValue int64 `xorm:"not null default 0"`
+}
+
+// migrations contains every migration of the database schema, ordered by version
+var migrations = []migration{
+	{
+		version:     1,
+		description: "Create the initial tables",
+		// Databases created before the migrations existed already have these tables, so this only adds what's missing
+		up: func(session *xorm.Session) error {
+			return syncTables(session,
+				migrationTable{"ShortenedURL", new(shortenedURLV1)},
+				migrationTable{"ShortenedURLVisitsHistory", new(shortenedURLVisitsHistoryV1)},
+				migrationTable{"UserShortenedURL", new(userShortenedURLV1)},
+				migrationTable{"User", new(userV1)},
+				migrationTable{"UserToken", new(userTokenV1)},
+			)
+		},
+		down: func(session *xorm.Session) error {
+			return dropTables(session, "ShortenedURL", "ShortenedURLVisitsHistory", "UserShortenedURL", "User", "UserToken")
+		},
+	},
+	{
+		version:     2,
+		description: "Add expiration dates to ShortenedURL",
+		up: func(session *xorm.Session) error {
+			return addColumns(session, migrationTable{"ShortenedURL", new(shortenedURLV2)}, "ExpiresAt", "ArchivedAt")
+		},
+		down: func(session *xorm.Session) error {
+			return dropColumns(session, "ShortenedURL", "ExpiresAt", "ArchivedAt")
+		},
+	},
+	{
+		version:     3,
+		description: "Add visit limits to ShortenedURL",
+		up: func(session *xorm.Session) error {
+			err := addColumns(session, migrationTable{"ShortenedURL", new(shortenedURLV3)}, "MaxVisits", "Visits")
+			if err != nil {
+				return err
+			}
+
+			// Start counting from the visits that are already in the history
+			_, err = session.Exec("UPDATE ShortenedURL SET Visits = (SELECT COUNT(*) FROM ShortenedURLVisitsHistory WHERE ShortenedURLVisitsHistory.ShortenedURLID = ShortenedURL.ID)")
+			return err
+		},
+		down: func(session *xorm.Session) error {
+			return dropColumns(session, "ShortenedURL", "MaxVisits", "Visits")
+		},
+	},
+	{
+		version:     4,
+		description: "Add passwords to ShortenedURL",
+		up: func(session *xorm.Session) error {
+			return addColumns(session, migrationTable{"ShortenedURL", new(shortenedURLV4)}, "Password")
+		},
+		down: func(session *xorm.Session) error {
+			return dropColumns(session, "ShortenedURL", "Password")
+		},
+	},
+	{
+		version:     5,
+		description: "Add activation windows to ShortenedURL",
+		up: func(session *xorm.Session) error {
+			return addColumns(session, migrationTable{"ShortenedURL", new(shortenedURLV5)}, "ActiveFrom", "ActiveUntil")
+		},
+		down: func(session *xorm.Session) error {
+			return dropColumns(session, "ShortenedURL", "ActiveFrom", "ActiveUntil")
+		},
+	},
+	{
+		version:     6,
+		description: "Create the ShortenedURLRevision table",
+		up: func(session *xorm.Session) error {
+			return syncTables(session, migrationTable{"ShortenedURLRevision", new(shortenedURLRevisionV1)})
+		},
+		down: func(session *xorm.Session) error {
+			return dropTables(session, "ShortenedURLRevision")
+		},
+	},
+	{
+		version:     7,
+		description: "Add redirect types to ShortenedURL",
+		up: func(session *xorm.Session) error {
+			return addColumns(session, migrationTable{"ShortenedURL", new(shortenedURLV7)}, "RedirectType")
+		},
+		down: func(session *xorm.Session) error {
+			return dropColumns(session, "ShortenedURL", "RedirectType")
+		},
+	},
+	{
+		version:     8,
+		description: "Add visitor details to ShortenedURLVisitsHistory",
+		up: func(session *xorm.Session) error {
+			return addColumns(session, migrationTable{"ShortenedURLVisitsHistory", new(shortenedURLVisitsHistoryV2)},
+				"Referrer", "UserAgent", "Browser", "OS", "DeviceClass", "AcceptLanguage", "IPHash")
+		},
+		down: func(session *xorm.Session) error {
+			return dropColumns(session, "ShortenedURLVisitsHistory", "Referrer", "UserAgent", "Browser", "OS", "DeviceClass", "AcceptLanguage", "IPHash")
+		},
+	},
+	{
+		version:     9,
+		description: "Create the RefreshToken table and add token families to UserToken",
+		up: func(session *xorm.Session) error {
+			err := addColumns(session, migrationTable{"UserToken", new(userTokenV2)}, "FamilyID")
+			if err != nil {
+				return err
+			}
+
+			return syncTables(session, migrationTable{"RefreshToken", new(refreshTokenV1)})
+		},
+		down: func(session *xorm.Session) error {
+			err := dropTables(session, "RefreshToken")
+			if err != nil {
+				return err
+			}
+
+			return dropColumns(session, "UserToken", "FamilyID")
+		},
+	},
+	{
+		version:     10,
+		description: "Add session details to UserToken",
+		up: func(session *xorm.Session) error {
+			// The tokens from before the token families expired and can't be refreshed, so they aren't sessions
+			_, err := session.Exec("DELETE FROM UserToken WHERE FamilyID IS NULL")
+			if err != nil {
+				return err
+			}
+
+			return addColumns(session, migrationTable{"UserToken", new(userTokenV3)}, "CreatedAt", "LastUsedAt", "UserAgent", "IP")
+		},
+		down: func(session *xorm.Session) error {
+			return dropColumns(session, "UserToken", "CreatedAt", "LastUsedAt", "UserAgent", "IP")
+		},
+	},
+	{
+		version:     11,
+		description: "Create the APIKey table",
+		up: func(session *xorm.Session) error {
+			return syncTables(session, migrationTable{"APIKey", new(apiKeyV1)})
+		},
+		down: func(session *xorm.Session) error {
+			return dropTables(session, "APIKey")
+		},
+	},
+	{
+		version:     12,
+		description: "Create the ShortURLCounter table",
+		up: func(session *xorm.Session) error {
+			err := syncTables(session, migrationTable{"ShortURLCounter", new(shortURLCounterV1)})
+			if err != nil {
+				return err
+			}
+
+			_, err = session.Exec("INSERT INTO ShortURLCounter (ID, LastValue) VALUES (1, 0)")
+			return err
+		},
+		down: func(session *xorm.Session) error {
+			return dropTables(session, "ShortURLCounter")
+		},
+	},
+}
@@ -0,0 +1,257 @@
+package store
+
+import (
+	"fmt"
+	"strings"
+
+	"xorm.io/xorm"
+)
+
+type migration struct {
+	version     int
+	description string
+	up          func(session *xorm.Session) error
+	down        func(session *xorm.Session) error
+}
+
+// migrationTable is a table that a migration creates or updates, name is the model name of the table
+type migrationTable struct {
+	name string
+	bean interface{}
+}
+
+func latestSchemaVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+// tableName returns the database name of a table using the mapper of the engine
+func tableName(session *xorm.Session, name string) string {
+	return session.Engine().GetTableMapper().Obj2Table(name)
+}
+
+// syncTables creates the tables or adds their missing columns and indexes
+func syncTables(session *xorm.Session, tables ...migrationTable) error {
+	for _, table := range tables {
+		err := session.Table(tableName(session, table.name)).Sync2(table.bean)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// addColumns adds the given columns of the bean to its existing table, unlike Sync2 it doesn't query through the
+// engine so it can't wait on the connection that the migration session holds
+func addColumns(session *xorm.Session, table migrationTable, columns ...string) error {
+	engine := session.Engine()
+	tableInfo, err := engine.TableInfo(table.bean)
+	if err != nil {
+		return err
+	}
+
+	for _, column := range columns {
+		col := tableInfo.GetColumn(engine.GetColumnMapper().Obj2Table(column))
+		if col == nil {
+			return fmt.Errorf("column %s doesn't exist on the %s model", column, table.name)
+		}
+
+		_, err = session.Exec(engine.Dialect().AddColumnSQL(tableName(session, table.name), col))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func dropTables(session *xorm.Session, names ...string) error {
+	for _, name := range names {
+		err := session.DropTable(tableName(session, name))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// dropColumns drops the columns of the table, SQLite supports this since 3.35 which go-sqlite3 bundles since v1.14.7
+func dropColumns(session *xorm.Session, table string, columns ...string) error {
+	engine := session.Engine()
+	for _, column := range columns {
+		columnName := engine.GetColumnMapper().Obj2Table(column)
+		_, err := session.Exec("ALTER TABLE " + engine.Quote(tableName(session, table)) + " DROP COLUMN " + engine.Quote(columnName))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// getSchemaVersion returns the version of the last applied migration, 0 means no migrations were applied
+func (s *storageService) getSchemaVersion() (int, error) {
+	historyExists, err := s.URLShortenerDB.IsTableExist(new(SchemaMigration))
+	if err != nil || !historyExists {
+		return 0, err
+	}
+
+	var lastMigration SchemaMigration
+	_, err = s.URLShortenerDB.OrderBy("Version DESC").Get(&lastMigration)
+	if err != nil {
+		return 0, err
+	}
+
+	return lastMigration.Version, nil
+}
+
+// migrateUp applies the pending migrations, steps limits how many are applied and 0 applies all of them
+func (s *storageService) migrateUp(steps int) error {
+	err := s.URLShortenerDB.Sync2(new(SchemaMigration))
+	if err != nil {
+		return err
+	}
+
+	version, err := s.getSchemaVersion()
+	if err != nil {
+		return err
+	}
+
+	applied := 0
+	for _, m := range migrations {
+		if m.version <= version {
+			continue
+		}
+		if steps > 0 && applied == steps {
+			break
+		}
+
+		fmt.Printf("Applying migration %d: %s...\n", m.version, m.description)
+		err = s.runMigration(m.up, func(session *xorm.Session) error {
+			_, err := session.Insert(&SchemaMigration{Version: m.version, Description: m.description})
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("migration %d failed: %w", m.version, err)
+		}
+
+		applied++
+	}
+
+	fmt.Printf("Applied %d migration(s), the schema is at version %d of %d\n", applied, version+applied, latestSchemaVersion())
+	return nil
+}
+
+// migrateDown rolls back the last applied migrations, steps is the number of migrations to roll back
+func (s *storageService) migrateDown(steps int) error {
+	version, err := s.getSchemaVersion()
+	if err != nil {
+		return err
+	}
+
+	rolledBack := 0
+	for index := len(migrations) - 1; index >= 0 && rolledBack < steps; index-- {
+		m := migrations[index]
+		if m.version > version {
+			continue
+		}
+
+		fmt.Printf("Rolling back migration %d: %s...\n", m.version, m.description)
+		err = s.runMigration(m.down, func(session *xorm.Session) error {
+			_, err := session.Delete(&SchemaMigration{Version: m.version})
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("rollback of migration %d failed: %w", m.version, err)
+		}
+
+		version = m.version - 1
+		rolledBack++
+	}
+
+	fmt.Printf("Rolled back %d migration(s), the schema is at version %d of %d\n", rolledBack, version, latestSchemaVersion())
+	return nil
+}
+
+// runMigration runs a migration and updates the migration history in one transaction.
+// Note that MySQL commits schema changes immediately, so a failed migration can still leave changes behind there.
+func (s *storageService) runMigration(change func(session *xorm.Session) error, updateHistory func(session *xorm.Session) error) error {
+	_, err := s.URLShortenerDB.Transaction(func(session *xorm.Session) (interface{}, error) {
+		err := change(session)
+		if err != nil {
+			return nil, err
+		}
+
+		return nil, updateHistory(session)
+	})
+
+	return err
+}
+
+func (s *storageService) printMigrationStatus() error {
+	version, err := s.getSchemaVersion()
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		status := "pending"
+		if m.version <= version {
+			status = "applied"
+		}
+
+		fmt.Printf("%4d  %-8s %s\n", m.version, status, m.description)
+	}
+
+	if version < latestSchemaVersion() {
+		fmt.Printf("The schema is behind: version %d of %d\n", version, latestSchemaVersion())
+	} else {
+		fmt.Printf("The schema is up to date: version %d\n", version)
+	}
+
+	return nil
+}
+
+// RunMigrateCommand runs the migrate subcommand: "migrate up [steps]", "migrate down [steps]" or "migrate status"
+func RunMigrateCommand(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: migrate up [steps] | down [steps] | status")
+	}
+
+	steps := 0
+	if len(args) > 1 {
+		_, err := fmt.Sscanf(args[1], "%d", &steps)
+		if err != nil || steps < 1 {
+			return fmt.Errorf("steps must be a positive number, got %q", args[1])
+		}
+	}
+
+	s, databaseExists := openStore()
+	defer s.URLShortenerDB.Close()
+
+	switch strings.ToLower(args[0]) {
+	case "up":
+		version, err := s.getSchemaVersion()
+		if err != nil {
+			return err
+		}
+
+		err = s.migrateUp(steps)
+		if err != nil {
+			return err
+		}
+
+		return s.seedNewDatabase(databaseExists, version)
+	case "down":
+		if steps == 0 {
+			steps = 1
+		}
+
+		return s.migrateDown(steps)
+	case "status":
+		return s.printMigrationStatus()
+	default:
+		return fmt.Errorf("unknown migrate command %q, use up, down or status", args[0])
+	}
+}
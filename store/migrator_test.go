@@ -0,0 +1,23 @@
+package store
+
+import "testing"
+
+func TestMigrationsRollBackAndApplyAgain(t *testing.T) {
+	s := newTestStore(t)
+
+	err := s.migrateDown(len(migrations))
+	if err != nil {
+		t.Fatalf("Failed to roll back the migrations: %v", err)
+	}
+	if version, err := s.getSchemaVersion(); version != 0 || err != nil {
+		t.Fatalf("The schema is at version %d after rolling back every migration: %v", version, err)
+	}
+
+	err = s.migrateUp(0)
+	if err != nil {
+		t.Fatalf("Failed to apply the migrations again: %v", err)
+	}
+	if version, err := s.getSchemaVersion(); version != latestSchemaVersion() || err != nil {
+		t.Errorf("The schema is at version %d, want %d: %v", version, latestSchemaVersion(), err)
+	}
+}
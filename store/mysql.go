@@ -0,0 +1,73 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/go-sql-driver/mysql" // Also registers the driver the XORM engine uses.
+	"xorm.io/xorm"
+	"xorm.io/xorm/names"
+)
+
+type mysqlDialect struct {
+	connectionString string
+}
+
+func (d mysqlDialect) openEngine() (*xorm.Engine, bool, error) {
+	engine, err := xorm.NewEngine("mysql", d.connectionString)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// Make the database names the same as the model names
+	engine.SetMapper(names.SameMapper{})
+
+	_, err = engine.DBMetas()
+	if err == nil {
+		return engine, true, nil
+	}
+	if !strings.Contains(err.Error(), "Unknown database") {
+		return nil, false, err
+	}
+
+	generalEngine, err := xorm.NewEngine("mysql", strings.Split(d.connectionString, "/")[0]+"/")
+	if err != nil {
+		return nil, false, err
+	}
+	defer generalEngine.Close()
+
+	databaseName := strings.Split(d.connectionString, "/")[1]
+	fmt.Println("Creating new database " + databaseName + "...")
+	_, err = generalEngine.Exec("CREATE DATABASE IF NOT EXISTS " + databaseName)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return engine, false, nil
+}
+
+func (d mysqlDialect) timeBucket(column string, interval string) string {
+	switch interval {
+	case "hour":
+		return "DATE_FORMAT(" + column + ", '%Y-%m-%d %H:00:00')"
+	case "week":
+		return "DATE_FORMAT(DATE_SUB(" + column + ", INTERVAL WEEKDAY(" + column + ") DAY), '%Y-%m-%d 00:00:00')"
+	default:
+		return "DATE_FORMAT(" + column + ", '%Y-%m-%d 00:00:00')"
+	}
+}
+
+func (d mysqlDialect) classifyError(err error) error {
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		switch mysqlErr.Number {
+		case 1062:
+			return newDatabaseError(ErrDuplicate, err)
+		case 1054:
+			return newDatabaseError(ErrMissingColumn, err)
+		}
+	}
+
+	return classifyCommonError(err)
+}
@@ -0,0 +1,16 @@
+package store
+
+import "time"
+
+// RefreshToken contains the hash of a refresh token, refreshing replaces it with a new RefreshToken in the same family
+// so a RefreshToken that is used twice was stolen and revokes its family
+type RefreshToken struct {
+	ID        string     `xorm:"pk not null unique"`
+	FamilyID  string     `xorm:"not null index"`
+	UserID    string     `xorm:"not null index"`
+	TokenHash string     `xorm:"not null unique"`
+	CreatedAt time.Time  `xorm:"not null default CURRENT_TIMESTAMP created"`
+	ExpiresAt time.Time  `xorm:"not null"`
+	UsedAt    *time.Time `xorm:"null"`
+	RevokedAt *time.Time `xorm:"null"`
+}
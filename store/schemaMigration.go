@@ -0,0 +1,10 @@
+package store
+
+import "time"
+
+// SchemaMigration contains a migration that was applied to the database schema
+type SchemaMigration struct {
+	Version     int       `xorm:"pk not null"`
+	Description string    `xorm:"not null"`
+	AppliedAt   time.Time `xorm:"not null default CURRENT_TIMESTAMP created"`
+}
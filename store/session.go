@@ -0,0 +1,21 @@
+package store
+
+import "time"
+
+// Session is a login of a user, it lasts as long as its token family can be refreshed and its ID is the ID of the token
+// family
+type Session struct {
+	ID         string     `json:"id"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+	UserAgent  string     `json:"userAgent"`
+	IP         string     `json:"ip"`
+	// Current is true for the session of the token that requested the sessions
+	Current bool `json:"current"`
+}
+
+// SessionClient is the device that a session was started or refreshed on
+type SessionClient struct {
+	UserAgent string
+	IP        string
+}
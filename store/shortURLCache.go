@@ -0,0 +1,216 @@
+package store
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/devlaminckduncan/url-shortener/cache"
+)
+
+const (
+	defaultShortURLCacheSize = 10000
+	defaultShortURLCacheTTL  = 5 * time.Minute
+	// Unknown short URLs are cached shorter so a new ShortenedURL that another replica created is found soon
+	maxMissingShortURLCacheTTL = 30 * time.Second
+
+	shortURLCacheKeyPrefix = "url-shortener:short-url:"
+	// shortURLCacheChannel is the Redis channel the replicas publish the changed short URLs on
+	shortURLCacheChannel = "url-shortener:short-url-changes"
+)
+
+// The cache counters are published on /debug/vars when ENABLE_METRICS is true
+var (
+	shortURLCacheHits   = expvar.NewInt("shortURLCacheHits")
+	shortURLCacheMisses = expvar.NewInt("shortURLCacheMisses")
+	shortURLCacheErrors = expvar.NewInt("shortURLCacheErrors")
+)
+
+// shortURLCache caches the ShortenedURLs that GetLongURL looks up by their short URL, unknown short URLs are cached as
+// a ShortenedURL without an ID. Failing cache operations are counted and treated as misses, the database still
+// works without the cache. A nil shortURLCache caches nothing.
+type shortURLCache struct {
+	cache      cache.Cache
+	ttl        time.Duration
+	missingTTL time.Duration
+}
+
+// cachedShortenedURL has the ShortenedURL fields that GetLongURL needs, the password hash stays in the database and only
+// whether the ShortenedURL is password protected is cached. Limited ShortenedURLs aren't cached so MaxVisits and Visits
+// aren't needed.
+type cachedShortenedURL struct {
+	ID           string     `json:"id"`
+	LongURL      string     `json:"longURL"`
+	ExpiresAt    *time.Time `json:"expiresAt,omitempty"`
+	ArchivedAt   *time.Time `json:"archivedAt,omitempty"`
+	ActiveFrom   *time.Time `json:"activeFrom,omitempty"`
+	ActiveUntil  *time.Time `json:"activeUntil,omitempty"`
+	RedirectType int        `json:"redirectType,omitempty"`
+	// PasswordProtected tells GetLongURL to fetch the password hash when a visitor gives a password
+	PasswordProtected bool `json:"passwordProtected,omitempty"`
+}
+
+// newShortURLCache returns a shortURLCache configured by the SHORT_URL_CACHE_SIZE, SHORT_URL_CACHE_TTL and REDIS_URL
+// environment variables, or nil when nothing should be cached. With REDIS_URL the replicas share the cache in Redis
+// and SHORT_URL_CACHE_SIZE sizes the in-memory layer in front of it.
+func newShortURLCache() *shortURLCache {
+	size := defaultShortURLCacheSize
+	if value := os.Getenv("SHORT_URL_CACHE_SIZE"); value != "" {
+		var err error
+		size, err = strconv.Atoi(value)
+		if err != nil || size < 0 {
+			panic(fmt.Sprintf("Invalid SHORT_URL_CACHE_SIZE %q, use a number of short URLs", value))
+		}
+	}
+
+	ttl := defaultShortURLCacheTTL
+	if value := os.Getenv("SHORT_URL_CACHE_TTL"); value != "" {
+		var err error
+		ttl, err = time.ParseDuration(value)
+		if err != nil || ttl < 0 {
+			panic(fmt.Sprintf("Invalid SHORT_URL_CACHE_TTL %q, use a duration like 30s or 5m", value))
+		}
+	}
+
+	redisURL := os.Getenv("REDIS_URL")
+	if ttl == 0 || (size == 0 && redisURL == "") {
+		return nil
+	}
+
+	missingTTL := ttl
+	if missingTTL > maxMissingShortURLCacheTTL {
+		missingTTL = maxMissingShortURLCacheTTL
+	}
+
+	c := &shortURLCache{
+		ttl:        ttl,
+		missingTTL: missingTTL,
+	}
+
+	var lru *cache.LRU
+	if size > 0 {
+		lru = cache.NewLRU(size)
+		if expvar.Get("shortURLCacheLength") == nil {
+			expvar.Publish("shortURLCacheLength", expvar.Func(func() interface{} {
+				return lru.Len()
+			}))
+		}
+	}
+
+	if redisURL == "" {
+		c.cache = lru
+		return c
+	}
+
+	redis, err := cache.NewRedis(redisURL)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to connect to Redis:\n%v", err))
+	}
+
+	if lru == nil {
+		c.cache = redis
+	} else {
+		c.cache = cache.NewLayered(lru, redis, ttl, shortURLCacheChannel)
+	}
+
+	return c
+}
+
+// get returns the cached ShortenedURL of the short URL without its password hash and whether it's password protected,
+// ok is false when the short URL isn't cached
+func (c *shortURLCache) get(shortURL string) (shortenedURL ShortenedURL, passwordProtected bool, ok bool) {
+	if c == nil {
+		return ShortenedURL{}, false, false
+	}
+
+	value, ok, err := c.cache.Get(shortURLCacheKeyPrefix + shortURL)
+	if err != nil {
+		shortURLCacheErrors.Add(1)
+	}
+	if !ok {
+		shortURLCacheMisses.Add(1)
+		return ShortenedURL{}, false, false
+	}
+
+	var cached cachedShortenedURL
+	err = json.Unmarshal(value, &cached)
+	if err != nil {
+		shortURLCacheErrors.Add(1)
+		shortURLCacheMisses.Add(1)
+		return ShortenedURL{}, false, false
+	}
+
+	shortURLCacheHits.Add(1)
+	return ShortenedURL{
+		ID:           cached.ID,
+		LongURL:      cached.LongURL,
+		ExpiresAt:    cached.ExpiresAt,
+		ArchivedAt:   cached.ArchivedAt,
+		ActiveFrom:   cached.ActiveFrom,
+		ActiveUntil:  cached.ActiveUntil,
+		RedirectType: cached.RedirectType,
+	}, cached.PasswordProtected, true
+}
+
+// set caches the ShortenedURL of the short URL, a ShortenedURL without an ID caches that the short URL doesn't exist
+func (c *shortURLCache) set(shortURL string, shortenedURL ShortenedURL) {
+	if c == nil {
+		return
+	}
+
+	value, err := json.Marshal(cachedShortenedURL{
+		ID:                shortenedURL.ID,
+		LongURL:           shortenedURL.LongURL,
+		ExpiresAt:         shortenedURL.ExpiresAt,
+		ArchivedAt:        shortenedURL.ArchivedAt,
+		ActiveFrom:        shortenedURL.ActiveFrom,
+		ActiveUntil:       shortenedURL.ActiveUntil,
+		RedirectType:      shortenedURL.RedirectType,
+		PasswordProtected: shortenedURL.Password != "",
+	})
+	if err != nil {
+		shortURLCacheErrors.Add(1)
+		return
+	}
+
+	ttl := c.ttl
+	if shortenedURL.ID == "" {
+		ttl = c.missingTTL
+	}
+
+	err = c.cache.Set(shortURLCacheKeyPrefix+shortURL, value, ttl)
+	if err != nil {
+		shortURLCacheErrors.Add(1)
+	}
+}
+
+// invalidate removes the short URLs from the cache after their ShortenedURLs were created, changed or deleted
+func (c *shortURLCache) invalidate(shortURLs ...string) {
+	if c == nil {
+		return
+	}
+
+	for _, shortURL := range shortURLs {
+		err := c.cache.Delete(shortURLCacheKeyPrefix + shortURL)
+		if err != nil {
+			shortURLCacheErrors.Add(1)
+		}
+	}
+}
+
+// close closes the connections of a shared cache
+func (c *shortURLCache) close() error {
+	if c == nil {
+		return nil
+	}
+
+	if closer, ok := c.cache.(io.Closer); ok {
+		return closer.Close()
+	}
+
+	return nil
+}
@@ -0,0 +1,41 @@
+package store
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/devlaminckduncan/url-shortener/cache"
+)
+
+func TestShortURLCacheLeavesThePasswordHashOut(t *testing.T) {
+	s := newTestStore(t)
+	lru := cache.NewLRU(10)
+	s.shortURLs = &shortURLCache{cache: lru, ttl: time.Minute, missingTTL: time.Minute}
+	user, _, _ := saveTestUser(t, s, "cached")
+	saveTestShortenedURL(t, s, user.ID, ShortenedURL{ShortURL: "cachedSecret", Password: "secret"})
+
+	// The first lookup caches the short URL, the others are served by the cache
+	for _, test := range []struct {
+		password   string
+		statusCode string
+	}{
+		{"", "PASSWORD_REQUIRED"},
+		{"", "PASSWORD_REQUIRED"},
+		{"wrong", "WRONG_PASSWORD"},
+		{"secret", "OK"},
+	} {
+		_, statusCode, err := s.GetLongURL([]string{"cachedSecret"}, test.password, ShortenedURLVisitsHistory{})
+		if statusCode != test.statusCode || err != nil {
+			t.Errorf("GetLongURL with password %q returned %s: %v, want %s", test.password, statusCode, err, test.statusCode)
+		}
+	}
+
+	value, ok, _ := lru.Get(shortURLCacheKeyPrefix + "cachedSecret")
+	if !ok {
+		t.Fatal("The short URL wasn't cached")
+	}
+	if strings.Contains(string(value), "$2a$") || !strings.Contains(string(value), `"passwordProtected":true`) {
+		t.Errorf("The short URL is cached as %s, want only that it's password protected", value)
+	}
+}
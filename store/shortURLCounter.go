@@ -0,0 +1,11 @@
+package store
+
+// ShortURLCounter holds the last value of the counter that the counter strategy encodes as short URLs, it has a single
+// row so restarts and every replica continue the same sequence
+type ShortURLCounter struct {
+	ID        int   `xorm:"pk not null"`
+	LastValue int64 `xorm:"not null default 0"`
+}
+
+// shortURLCounterID is the ID of the single ShortURLCounter row
+const shortURLCounterID = 1
@@ -0,0 +1,13 @@
+package store
+
+import "time"
+
+// ShortenedURLRevision contains a change of the long URL of a ShortenedURL with who changed it and when
+type ShortenedURLRevision struct {
+	ID              string    `json:"id" xorm:"pk not null unique"`
+	ShortenedURLID  string    `json:"shortenedURLID" xorm:"not null index"`
+	PreviousLongURL string    `json:"previousLongURL" xorm:"not null"`
+	NewLongURL      string    `json:"newLongURL" xorm:"not null"`
+	ChangedBy       string    `json:"changedBy" xorm:"not null"`
+	ChangedAt       time.Time `json:"changedAt" xorm:"not null default CURRENT_TIMESTAMP created"`
+}
@@ -2,8 +2,16 @@ package store
 
 import "time"
 
-// ShortenedURLVisitsHistory contains the datetime of every time someone visits a short URL
+// ShortenedURLVisitsHistory contains the datetime of every time someone visits a short URL with what the request tells
+// about the visitor, the IP address is only stored as a salted and truncated hash
 type ShortenedURLVisitsHistory struct {
-	ShortenedURLID string    `xorm:"not null"`
-	VisitedAt      time.Time `xorm:"not null default CURRENT_TIMESTAMP created"`
+	ShortenedURLID string    `json:"-" xorm:"not null"`
+	VisitedAt      time.Time `json:"visitedAt" xorm:"not null default CURRENT_TIMESTAMP created"`
+	Referrer       string    `json:"referrer,omitempty" xorm:"text null"`
+	UserAgent      string    `json:"userAgent,omitempty" xorm:"text null"`
+	Browser        string    `json:"browser,omitempty" xorm:"null"`
+	OS             string    `json:"os,omitempty" xorm:"null"`
+	DeviceClass    string    `json:"deviceClass,omitempty" xorm:"null"`
+	AcceptLanguage string    `json:"acceptLanguage,omitempty" xorm:"null"`
+	IPHash         string    `json:"ipHash,omitempty" xorm:"null"`
 }
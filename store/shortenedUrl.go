@@ -4,9 +4,58 @@ import "time"
 
 // ShortenedURL contains a short URL with its associated data
 type ShortenedURL struct {
-	ID        string    `json:"id" xorm:"pk not null unique"`
-	Name      string    `json:"name" xorm:"not null"`
-	CreatedAt time.Time `json:"createdAt" xorm:"not null default CURRENT_TIMESTAMP created"`
-	ShortURL  string    `json:"shortURL" xorm:"not null unique"`
-	LongURL   string    `json:"longURL" xorm:"not null"`
+	ID        string     `json:"id" xorm:"pk not null unique"`
+	Name      string     `json:"name" xorm:"not null"`
+	CreatedAt time.Time  `json:"createdAt" xorm:"not null default CURRENT_TIMESTAMP created"`
+	ShortURL  string     `json:"shortURL" xorm:"not null unique"`
+	LongURL   string     `json:"longURL" xorm:"not null"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty" xorm:"null"`
+	// ArchivedAt is set by the sweeper when it finds that the ShortenedURL expired
+	ArchivedAt *time.Time `json:"archivedAt,omitempty" xorm:"null"`
+	// MaxVisits limits how many times the ShortenedURL redirects, 0 means unlimited
+	MaxVisits int `json:"maxVisits,omitempty" xorm:"not null default 0"`
+	Visits    int `json:"visits" xorm:"not null default 0"`
+	// ActiveFrom and ActiveUntil optionally limit when the ShortenedURL redirects
+	ActiveFrom  *time.Time `json:"activeFrom,omitempty" xorm:"null"`
+	ActiveUntil *time.Time `json:"activeUntil,omitempty" xorm:"null"`
+	// RedirectType is the HTTP status code of the redirect (301, 302, 307 or 308), 0 uses the server default
+	RedirectType int `json:"redirectType,omitempty" xorm:"not null default 0"`
+	// Password is the bcrypt hash of the password that protects the ShortenedURL, empty when it isn't protected
+	Password string `json:"-" xorm:"null"`
+}
+
+// RemainingVisits returns how many times a ShortenedURL with MaxVisits can still redirect, nil means unlimited
+func (shortenedURL ShortenedURL) RemainingVisits() *int {
+	if shortenedURL.MaxVisits == 0 {
+		return nil
+	}
+
+	remainingVisits := shortenedURL.MaxVisits - shortenedURL.Visits
+	if remainingVisits < 0 {
+		remainingVisits = 0
+	}
+
+	return &remainingVisits
+}
+
+// HasVisitRestrictions checks whether the ShortenedURL can stop redirecting or doesn't redirect every visitor, so
+// its redirects shouldn't be cached
+func (shortenedURL ShortenedURL) HasVisitRestrictions() bool {
+	return shortenedURL.ExpiresAt != nil || shortenedURL.MaxVisits > 0 || shortenedURL.ActiveFrom != nil ||
+		shortenedURL.ActiveUntil != nil || shortenedURL.Password != ""
+}
+
+// IsNotYetActive checks whether the ShortenedURL's activation window starts after the time
+func (shortenedURL ShortenedURL) IsNotYetActive(now time.Time) bool {
+	return shortenedURL.ActiveFrom != nil && now.Before(*shortenedURL.ActiveFrom)
+}
+
+// IsNoLongerActive checks whether the ShortenedURL's activation window ended at or before the time
+func (shortenedURL ShortenedURL) IsNoLongerActive(now time.Time) bool {
+	return shortenedURL.ActiveUntil != nil && !now.Before(*shortenedURL.ActiveUntil)
+}
+
+// IsExpired checks whether the ShortenedURL expired or was archived
+func (shortenedURL ShortenedURL) IsExpired(now time.Time) bool {
+	return shortenedURL.ArchivedAt != nil || (shortenedURL.ExpiresAt != nil && !now.Before(*shortenedURL.ExpiresAt))
 }
@@ -0,0 +1,77 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/mattn/go-sqlite3" // Also registers the driver the XORM engine uses.
+	"xorm.io/xorm"
+	"xorm.io/xorm/names"
+)
+
+const defaultSQLiteDatabasePath = "URLShortenerDB.db"
+
+type sqliteDialect struct {
+	// path is the file of the database, ":memory:" keeps the database in memory
+	path string
+}
+
+func (d sqliteDialect) openEngine() (*xorm.Engine, bool, error) {
+	path := d.path
+	if path == "" {
+		path = defaultSQLiteDatabasePath
+	}
+
+	databaseExists := false
+	if path != ":memory:" {
+		if _, err := os.Stat(path); err == nil {
+			databaseExists = true
+		} else {
+			fmt.Println("Creating new database " + path + "...")
+		}
+	}
+
+	engine, err := xorm.NewEngine("sqlite3", path)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// Make the database names the same as the model names
+	engine.SetMapper(names.SameMapper{})
+
+	// SQLite only allows one writer at a time and every connection to ":memory:" opens a different database,
+	// so all queries have to share a single connection
+	engine.SetMaxOpenConns(1)
+
+	return engine, databaseExists, nil
+}
+
+func (d sqliteDialect) timeBucket(column string, interval string) string {
+	switch interval {
+	case "hour":
+		return "strftime('%Y-%m-%d %H:00:00', " + column + ")"
+	case "week":
+		// Go to the next Sunday (unless it's Sunday already) and back to the Monday before it
+		return "strftime('%Y-%m-%d 00:00:00', " + column + ", 'weekday 0', '-6 days')"
+	default:
+		return "strftime('%Y-%m-%d 00:00:00', " + column + ")"
+	}
+}
+
+func (d sqliteDialect) classifyError(err error) error {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		if sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique || sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey {
+			return newDatabaseError(ErrDuplicate, err)
+		}
+
+		// SQLite doesn't have a separate error code for unknown columns
+		if sqliteErr.Code == sqlite3.ErrError && strings.HasPrefix(sqliteErr.Error(), "no such column") {
+			return newDatabaseError(ErrMissingColumn, err)
+		}
+	}
+
+	return classifyCommonError(err)
+}
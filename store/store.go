@@ -0,0 +1,96 @@
+package store
+
+import "time"
+
+// Store contains every operation the handlers need from the storage backend
+type Store interface {
+	UserStore
+	TokenStore
+	APIKeyStore
+	ShortenedURLStore
+	VisitStore
+	// Close saves what's still queued and closes the storage backend
+	Close() error
+}
+
+// UserStore manages the User accounts
+type UserStore interface {
+	// CheckUserExists checks if the given user ID, username or email exists
+	CheckUserExists(uniqueValue string) (bool, string, error)
+	// SaveUser saves a new User and returns a TokenPair of a new session on the client and the user's ID
+	SaveUser(user User, client SessionClient) (TokenPair, string, string, error)
+	// GetUser returns a User by ID, username or email
+	GetUser(uniqueValue string) (User, string, error)
+	// UpdateUser updates a User and returns a new security token if the username changed
+	UpdateUser(user User, token string) (string, string, error)
+	// DeleteUser deletes a User with all of its tokens, API keys and ShortenedURLs
+	DeleteUser(id string) (string, error)
+	// CheckLogin compares the given password with the stored one and returns a TokenPair of a new session on the client
+	// and the user's ID
+	CheckLogin(user User, client SessionClient) (TokenPair, string, string, error)
+}
+
+// TokenStore manages the security tokens and sessions of the users, every login starts a session with a token family of
+// short-lived access tokens and the refresh tokens that replace them
+type TokenStore interface {
+	// GenerateTokenPair starts a new session on the client for the given User with an access token and a refresh token
+	GenerateTokenPair(user User, client SessionClient) (TokenPair, string, error)
+	// RefreshTokenPair replaces the refresh token with a new TokenPair in its family, using a refresh token twice
+	// revokes the family
+	RefreshTokenPair(refreshToken string, client SessionClient) (TokenPair, string, error)
+	// CheckSecurityTokenExists checks whether the given access token was saved and isn't revoked and records that its
+	// session was used
+	CheckSecurityTokenExists(tokenString string) (bool, string, error)
+	// RevokeSecurityToken revokes the token family of the given access token
+	RevokeSecurityToken(token string) (string, error)
+	// RevokeRefreshToken revokes the token family of the given refresh token
+	RevokeRefreshToken(refreshToken string) (string, error)
+	// GetUserSessions returns the sessions of the user that can still be refreshed and marks the one of the current
+	// token
+	GetUserSessions(userID string, currentToken string) ([]Session, string, error)
+	// RevokeUserSession revokes a session of the user
+	RevokeUserSession(userID string, sessionID string) (string, error)
+}
+
+// APIKeyStore manages the API keys that the users create for scripts
+type APIKeyStore interface {
+	// SaveAPIKey saves a new APIKey for its user and returns it with the key, which isn't stored
+	SaveAPIKey(apiKey APIKey) (APIKey, string, string, error)
+	// CheckAPIKey returns the APIKey of the key when it exists and didn't expire and records that it was used
+	CheckAPIKey(key string) (APIKey, string, error)
+	// GetUserAPIKeys returns the APIKeys of the user
+	GetUserAPIKeys(userID string) ([]APIKey, string, error)
+	// DeleteAPIKey revokes an APIKey of the user
+	DeleteAPIKey(userID string, id string) (string, error)
+}
+
+// ShortenedURLStore manages the ShortenedURLs and their link with the users
+type ShortenedURLStore interface {
+	// SaveURL saves a new ShortenedURL for the given user
+	SaveURL(shortenedURL ShortenedURL, userID string) (ShortenedURL, string, error)
+	// NextShortURLCounter increments the counter of the counter strategy and returns its new value
+	NextShortURLCounter() (uint64, string, error)
+	// GetUserShortenedURLByLongURL returns the oldest usable ShortenedURL of the user that redirects to the given long URL
+	GetUserShortenedURLByLongURL(userID string, longURL string) (ShortenedURL, string, error)
+	// UpdateShortenedURL updates the given ShortenedURL and records a ShortenedURLRevision when the long URL changes, the
+	// clear columns are set to NULL or their zero value
+	UpdateShortenedURL(shortenedURL ShortenedURL, changedBy string, clearColumns ...string) (string, error)
+	// ArchiveExpiredShortenedURLs archives the ShortenedURLs that expired and returns how many it archived
+	ArchiveExpiredShortenedURLs() (int64, string, error)
+	// CheckShortenedURLOwner checks whether the user created the ShortenedURL
+	CheckShortenedURLOwner(userID string, id string) (bool, string, error)
+	// DeleteShortenedURL deletes a ShortenedURL with its analytics
+	DeleteShortenedURL(id string) (string, error)
+	// GetUserShortenedURLs returns all ShortenedURLs with analytics that a user created
+	GetUserShortenedURLs(userID string) ([]ShortenedURLData, string, error)
+}
+
+// VisitStore resolves short URLs and keeps track of their visits
+type VisitStore interface {
+	// GetLongURL returns the ShortenedURL of the first of the short URLs that exists and queues the visit, unless the
+	// ShortenedURL expired, isn't active, has no visits left or the password doesn't match. The short URLs are the
+	// spellings that a requested short URL can refer to, they're looked up at once.
+	GetLongURL(shortURLs []string, password string, visit ShortenedURLVisitsHistory) (ShortenedURL, string, error)
+	// GetShortenedURLVisitStats counts the visits of a ShortenedURL in a time range per hour, day or week
+	GetShortenedURLVisitStats(id string, from time.Time, to time.Time, interval string) (VisitStats, string, error)
+}
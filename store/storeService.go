@@ -1,6 +1,11 @@
 package store
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"os"
 	"strings"
@@ -10,57 +15,34 @@ import (
 
 	"golang.org/x/crypto/bcrypt"
 	"xorm.io/xorm/log"
-	"xorm.io/xorm/names"
 
 	"github.com/dgrijalva/jwt-go"
-	_ "github.com/go-sql-driver/mysql" // The XORM engine uses this package.
 
 	uuid "github.com/satori/go.uuid"
 	"xorm.io/xorm"
 )
 
+// storageService is the Store implementation that uses an XORM engine
 type storageService struct {
 	URLShortenerDB *xorm.Engine
+	dialect        dialect
+	// visits saves the visits in the background, it's only started for the web server
+	visits *visitRecorder
+	// shortURLs caches the lookups of GetLongURL, it's only started for the web server
+	shortURLs *shortURLCache
 }
 
-var storeService = &storageService{}
+var enableLogger, enableSeedDatabase, enableAutoMigrate = true, true, false
 
-var enableLogger, enableSeedDatabase = true, true
+// openStore opens the database of the selected driver and creates it if it doesn't exist, it also returns whether the database already existed
+func openStore() (*storageService, bool) {
+	storeDialect := newDialect()
 
-// InitializeStore creates the database if it doesn't exist and it synchronizes the tables.
-func InitializeStore() {
-	databaseDriver := "mysql"
-	connectionString := os.Getenv("MYSQL_CONNECTION_STRING")
-
-	var engine *xorm.Engine
-	engine, err := xorm.NewEngine(databaseDriver, connectionString)
+	engine, databaseExists, err := storeDialect.openEngine()
 	if err != nil {
 		panic(fmt.Sprintf("Failed to open database:\n%d", err))
 	}
 
-	_, err = engine.DBMetas()
-	databaseExists := true
-	if err != nil {
-		if strings.Contains(err.Error(), "Unknown database") {
-			databaseExists = false
-		} else {
-			panic(fmt.Sprintf("Failed to get database metas:\n%d", err))
-		}
-	}
-	if !databaseExists {
-		generalEngine, err := xorm.NewEngine(databaseDriver, strings.Split(connectionString, "/")[0]+"/")
-		if err != nil {
-			panic(fmt.Sprintf("Failed to open database:\n%d", err))
-		}
-
-		databaseName := strings.Split(connectionString, "/")[1]
-		fmt.Println("Creating new database " + databaseName + "...")
-		_, err = generalEngine.Exec("CREATE DATABASE IF NOT EXISTS " + databaseName)
-		if err != nil {
-			panic(fmt.Sprintf("Failed to create database:\n%d", err))
-		}
-	}
-
 	if os.Getenv("ENABLE_LOGGER") == "false" {
 		enableLogger = false
 	}
@@ -74,34 +56,90 @@ func InitializeStore() {
 		engine.SetLogger(log.NewSimpleLogger(logWriter))
 	}
 
-	storeService.URLShortenerDB = engine
+	if os.Getenv("ENABLE_SEED_DATABASE") == "false" {
+		enableSeedDatabase = false
+	}
 
-	// Make the database names the same as the model names
-	engine.SetMapper(names.SameMapper{})
+	if os.Getenv("ENABLE_AUTO_MIGRATE") == "true" {
+		enableAutoMigrate = true
+	}
 
-	fmt.Println("Syncing tables...")
-	engine.Sync2(new(ShortenedURL))
-	engine.Sync2(new(ShortenedURLVisitsHistory))
-	engine.Sync2(new(UserShortenedURL))
-	engine.Sync2(new(User))
-	engine.Sync2(new(UserToken))
-	fmt.Println("Finished syncing tables!")
+	s := &storageService{
+		URLShortenerDB: engine,
+		dialect:        storeDialect,
+	}
 
-	if os.Getenv("ENABLE_SEED_DATABASE") == "false" {
-		enableSeedDatabase = false
+	return s, databaseExists
+}
+
+// InitializeStore opens the database, makes sure its schema is up to date and returns the XORM Store
+func InitializeStore() Store {
+	s, databaseExists := openStore()
+
+	version, err := s.getSchemaVersion()
+	if err != nil {
+		panic(fmt.Sprintf("Failed to get the schema version:\n%v", err))
 	}
 
-	if !databaseExists && enableSeedDatabase {
-		err = seedDatabase()
+	if version < latestSchemaVersion() {
+		if !enableAutoMigrate {
+			panic(fmt.Sprintf("The database schema is at version %d but version %d is required, run \"go run main.go migrate up\" first", version, latestSchemaVersion()))
+		}
+
+		err = s.migrateUp(0)
+		if err != nil {
+			panic(fmt.Sprintf("Failed to migrate database:\n%v", err))
+		}
+
+		err = s.seedNewDatabase(databaseExists, version)
 		if err != nil {
 			panic(fmt.Sprintf("Failed to seed database:\n%d", err))
 		}
 	}
+
+	s.visits = newVisitRecorder(s)
+	s.shortURLs = newShortURLCache()
+
+	return s
+}
+
+// Close saves the queued visits and closes the cache and the database
+func (s *storageService) Close() error {
+	s.visits.close()
+
+	err := s.shortURLs.close()
+	if err != nil {
+		s.logError("Failed to close the short URL cache:\n" + err.Error())
+	}
+
+	return s.URLShortenerDB.Close()
 }
 
-func seedDatabase() error {
+// seedNewDatabase seeds the database if it was just created and migrated from version 0 to the latest version
+func (s *storageService) seedNewDatabase(databaseExists bool, previousVersion int) error {
+	if databaseExists || previousVersion != 0 || !enableSeedDatabase {
+		return nil
+	}
+
+	version, err := s.getSchemaVersion()
+	if err != nil || version != latestSchemaVersion() {
+		return err
+	}
+
+	return s.seedDatabase()
+}
+
+func (s *storageService) seedDatabase() error {
 	fmt.Println("Seeding database...")
 
+	session := s.URLShortenerDB.NewSession()
+	defer session.Close()
+
+	err := session.Begin()
+	if err != nil {
+		return err
+	}
+
 	user := User{
 		ID:        "e0dba740-fc4b-4977-872c-d360239e6b1b",
 		FirstName: "Duncan",
@@ -113,14 +151,14 @@ func seedDatabase() error {
 
 	hash, err := generatePasswordHash(user.Password)
 	if err != nil {
-		logError("Failed to generate password hash:\n" + err.Error())
+		s.logError("Failed to generate password hash:\n" + err.Error())
 		return err
 	}
 	user.Password = hash
 
-	_, err = storeService.URLShortenerDB.Insert(&user)
+	_, err = session.Insert(&user)
 	if err != nil {
-		logError("Failed to insert data into table User:\n" + err.Error())
+		s.logError("Failed to insert data into table User:\n" + err.Error())
 		return err
 	}
 
@@ -135,9 +173,9 @@ func seedDatabase() error {
 		},
 	}
 
-	_, err = storeService.URLShortenerDB.Insert(&userTokens)
+	_, err = session.Insert(&userTokens)
 	if err != nil {
-		logError("Failed to insert data into table UserToken:\n" + err.Error())
+		s.logError("Failed to insert data into table UserToken:\n" + err.Error())
 		return err
 	}
 
@@ -159,9 +197,9 @@ func seedDatabase() error {
 		shortenedURLs[index].ShortURL = shortURL
 	}
 
-	_, err = storeService.URLShortenerDB.Insert(&shortenedURLs)
+	_, err = session.Insert(&shortenedURLs)
 	if err != nil {
-		logError("Failed to insert data into table ShortenedURL:\n" + err.Error())
+		s.logError("Failed to insert data into table ShortenedURL:\n" + err.Error())
 		return err
 	}
 
@@ -174,9 +212,15 @@ func seedDatabase() error {
 		})
 	}
 
-	_, err = storeService.URLShortenerDB.Insert(&userShortenedURLs)
+	_, err = session.Insert(&userShortenedURLs)
 	if err != nil {
-		logError("Failed to insert url data into table UserShortenedURL:\n" + err.Error())
+		s.logError("Failed to insert url data into table UserShortenedURL:\n" + err.Error())
+		return err
+	}
+
+	err = session.Commit()
+	if err != nil {
+		s.logError("Failed to commit seed data:\n" + err.Error())
 		return err
 	}
 
@@ -184,22 +228,32 @@ func seedDatabase() error {
 	return nil
 }
 
-func logError(errStr string) {
+func (s *storageService) logError(errStr string) {
 	if enableLogger {
 		fmt.Println(errStr)
-		storeService.URLShortenerDB.Logger().Errorf(errStr)
+		s.URLShortenerDB.Logger().Errorf(errStr)
 	}
 }
 
-func checkShortenedURLExists(id string) (bool, error) {
+// databaseTimeLayout is how XORM writes times to the database
+const databaseTimeLayout = "2006-01-02 15:04:05"
+
+// databaseTime formats a time like XORM stores it, in the timezone of the database, so raw SQL compares it with the
+// stored times instead of a time that the driver formats with another timezone or offset
+func (s *storageService) databaseTime(t time.Time) string {
+	return t.In(s.URLShortenerDB.DatabaseTZ).Format(databaseTimeLayout)
+}
+
+// getShortURL returns the short URL of a ShortenedURL by ID, so it can be removed from the cache when it changes
+func (s *storageService) getShortURL(id string) (string, bool, error) {
 	var shortenedURL ShortenedURL
-	shortenedURLExists, err := storeService.URLShortenerDB.Table(&shortenedURL).Where("ID = ?", id).Exist()
+	shortenedURLExists, err := s.URLShortenerDB.Table(&shortenedURL).Select("ShortURL").Where("ID = ?", id).Get(&shortenedURL)
 	if err != nil {
-		logError("Failed to fetch ShortenedURL data:\n" + err.Error())
-		return false, err
+		s.logError("Failed to fetch ShortenedURL data:\n" + err.Error())
+		return "", false, err
 	}
 
-	return shortenedURLExists, nil
+	return shortenedURL.ShortURL, shortenedURLExists, nil
 }
 
 func generatePasswordHash(password string) (string, error) {
@@ -211,12 +265,84 @@ func generatePasswordHash(password string) (string, error) {
 	return string(hash), nil
 }
 
-// GenerateSecurityToken creates a new security token using a username and ID and saves it in the database
-func GenerateSecurityToken(user User) (string, string, error) {
-	expirationTime := time.Now().Add(5 * time.Minute)
+// shortURLPasswordCost is the bcrypt cost of the short URL passwords, anyone who has a short URL can guess its password
+// so every guess is made slow
+const shortURLPasswordCost = bcrypt.DefaultCost
+
+func generateShortURLPasswordHash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), shortURLPasswordCost)
+	if err != nil {
+		return "", err
+	}
+
+	return string(hash), nil
+}
+
+const (
+	accessTokenLifetime  = 5 * time.Minute
+	refreshTokenLifetime = 30 * 24 * time.Hour
+	// sessionLastUsedInterval is how often the last use of a session or API key is updated
+	sessionLastUsedInterval = time.Minute
+	// apiKeyPrefixLength is how much of an API key is kept to tell the keys apart
+	apiKeyPrefixLength = len(APIKeyPrefix) + 6
+)
+
+// GenerateTokenPair starts a new session for the user with an access token and a refresh token in a new token family,
+// the sessions of the user that ended are deleted
+func (s *storageService) GenerateTokenPair(user User, client SessionClient) (TokenPair, string, error) {
+	var tokenPair TokenPair
+	statusCode, err := s.transaction(func(session *xorm.Session) (string, error) {
+		statusCode, err := s.deleteEndedSessions(session, user.ID)
+		if statusCode != "OK" || err != nil {
+			return statusCode, err
+		}
+
+		tokenPair, statusCode, err = s.generateTokenPair(session, user, newSessionToken(client))
+		return statusCode, err
+	})
+	if statusCode != "OK" || err != nil {
+		return TokenPair{}, statusCode, err
+	}
+
+	return tokenPair, "OK", nil
+}
+
+// newSessionToken returns the UserToken details of a new session on the client
+func newSessionToken(client SessionClient) UserToken {
+	now := time.Now()
+	return UserToken{
+		FamilyID:   uuid.NewV4().String(),
+		CreatedAt:  now,
+		LastUsedAt: &now,
+		UserAgent:  client.UserAgent,
+		IP:         client.IP,
+	}
+}
+
+// generateTokenPair creates an access token and a refresh token in the token family of the session
+func (s *storageService) generateTokenPair(db xorm.Interface, user User, session UserToken) (TokenPair, string, error) {
+	accessToken, statusCode, err := s.generateSecurityToken(db, user, session)
+	if statusCode != "OK" || err != nil {
+		return TokenPair{}, statusCode, err
+	}
+
+	refreshToken, statusCode, err := s.generateRefreshToken(db, user.ID, session.FamilyID)
+	if statusCode != "OK" || err != nil {
+		return TokenPair{}, statusCode, err
+	}
+
+	return TokenPair{AccessToken: accessToken, RefreshToken: refreshToken}, "OK", nil
+}
+
+// generateSecurityToken creates a new access token using a username and saves it in the database with the details of
+// its session
+func (s *storageService) generateSecurityToken(db xorm.Interface, user User, session UserToken) (string, string, error) {
+	expirationTime := time.Now().Add(accessTokenLifetime)
 	claims := &JWTClaims{
 		Username: user.Username,
 		StandardClaims: jwt.StandardClaims{
+			// The ID makes every token unique, also when the same user gets two tokens in the same second
+			Id:        uuid.NewV4().String(),
 			ExpiresAt: expirationTime.Unix(),
 		},
 	}
@@ -225,95 +351,633 @@ func GenerateSecurityToken(user User) (string, string, error) {
 	jwtKey := []byte(os.Getenv("SECRET_JWT_KEY"))
 	tokenString, err := token.SignedString(jwtKey)
 	if err != nil {
-		logError("Failed to create token string:\n" + err.Error())
+		s.logError("Failed to create token string:\n" + err.Error())
 		return "", "ERROR_CREATING_TOKEN", err
 	}
 
-	userToken := UserToken{
-		UserID: user.ID,
-		Token:  []byte(tokenString),
-	}
-	_, err = storeService.URLShortenerDB.Insert(&userToken)
+	session.UserID = user.ID
+	session.Token = []byte(tokenString)
+	_, err = db.Insert(&session)
 	if err != nil {
-		logError("Failed to insert data into table UserToken:\n" + err.Error())
+		s.logError("Failed to insert data into table UserToken:\n" + err.Error())
 		return "", "ERROR_INSERTING_USERTOKEN", err
 	}
 
 	return tokenString, "OK", nil
 }
 
-// CheckSecurityTokenExists checks whether the given security token exists in the database and if it expired
-func CheckSecurityTokenExists(tokenString string) (bool, string, error) {
-	tokenExists, err := storeService.URLShortenerDB.Table(&UserToken{}).Where("Token = ?", tokenString).Exist()
+// generateRefreshToken creates a new random refresh token and saves its hash in the database
+func (s *storageService) generateRefreshToken(db xorm.Interface, userID string, familyID string) (string, string, error) {
+	refreshToken, err := generateRandomToken()
+	if err != nil {
+		s.logError("Failed to create refresh token:\n" + err.Error())
+		return "", "ERROR_CREATING_TOKEN", err
+	}
+
+	row := RefreshToken{
+		ID:        uuid.NewV4().String(),
+		FamilyID:  familyID,
+		UserID:    userID,
+		TokenHash: hashToken(refreshToken),
+		ExpiresAt: time.Now().Add(refreshTokenLifetime),
+	}
+	_, err = db.Insert(&row)
+	if err != nil {
+		s.logError("Failed to insert data into table RefreshToken:\n" + err.Error())
+		return "", "ERROR_INSERTING_REFRESHTOKEN", err
+	}
+
+	return refreshToken, "OK", nil
+}
+
+// generateRandomToken returns 32 random bytes encoded for URLs
+func generateRandomToken() (string, error) {
+	randomBytes := make([]byte, 32)
+	_, err := rand.Read(randomBytes)
 	if err != nil {
-		logError("Failed to fetch UserToken data:\n" + err.Error())
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(randomBytes), nil
+}
+
+// hashToken hashes a refresh token or API key with SHA-256, which is enough because they're random and long
+func hashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+// RefreshTokenPair marks the refresh token as used and returns a new TokenPair in the same family, the previous access
+// tokens of the family are deleted and the session is now on the client. Using a refresh token a second time revokes
+// the family.
+func (s *storageService) RefreshTokenPair(refreshToken string, client SessionClient) (TokenPair, string, error) {
+	var row RefreshToken
+	refreshTokenExists, err := s.URLShortenerDB.Where("TokenHash = ?", hashToken(refreshToken)).Get(&row)
+	if err != nil {
+		s.logError("Failed to fetch RefreshToken data:\n" + err.Error())
+		return TokenPair{}, "ERROR_FETCHING_REFRESHTOKEN", err
+	}
+	if !refreshTokenExists {
+		return TokenPair{}, "NON_EXISTING_REFRESHTOKEN", nil
+	}
+	if row.RevokedAt != nil {
+		return TokenPair{}, "REVOKED_REFRESHTOKEN", nil
+	}
+	if row.UsedAt != nil {
+		statusCode, err := s.revokeReusedRefreshToken(row)
+		return TokenPair{}, statusCode, err
+	}
+
+	now := time.Now()
+	if !now.Before(row.ExpiresAt) {
+		return TokenPair{}, "EXPIRED_REFRESHTOKEN", nil
+	}
+
+	user, statusCode, err := s.GetUser(row.UserID)
+	if statusCode != "OK" || err != nil {
+		return TokenPair{}, statusCode, err
+	}
+
+	var tokenPair TokenPair
+	statusCode, err = s.transaction(func(session *xorm.Session) (string, error) {
+		// The condition makes sure that only one of two concurrent refreshes with the same refresh token succeeds
+		used, err := session.Where("ID = ? AND UsedAt IS NULL AND RevokedAt IS NULL", row.ID).Update(&RefreshToken{UsedAt: &now})
+		if err != nil {
+			s.logError("Failed to update data in table RefreshToken:\n" + err.Error())
+			return "ERROR_UPDATING_REFRESHTOKEN", err
+		}
+		if used == 0 {
+			return "REUSED_REFRESHTOKEN", nil
+		}
+
+		// The session started when the user logged in
+		var previousToken UserToken
+		_, err = session.Table(&previousToken).Select("CreatedAt").Where("FamilyID = ?", row.FamilyID).Get(&previousToken)
+		if err != nil {
+			s.logError("Failed to fetch UserToken data:\n" + err.Error())
+			return "ERROR_FETCHING_USERTOKEN", err
+		}
+
+		_, err = session.Where("FamilyID = ?", row.FamilyID).Delete(&UserToken{})
+		if err != nil {
+			s.logError("Failed to delete data from table UserToken:\n" + err.Error())
+			return "ERROR_DELETING_USERTOKEN", err
+		}
+
+		sessionToken := newSessionToken(client)
+		sessionToken.FamilyID = row.FamilyID
+		if !previousToken.CreatedAt.IsZero() {
+			sessionToken.CreatedAt = previousToken.CreatedAt
+		}
+
+		var statusCode string
+		tokenPair, statusCode, err = s.generateTokenPair(session, user, sessionToken)
+		return statusCode, err
+	})
+	if statusCode == "REUSED_REFRESHTOKEN" && err == nil {
+		statusCode, err = s.revokeReusedRefreshToken(row)
+	}
+	if statusCode != "OK" || err != nil {
+		return TokenPair{}, statusCode, err
+	}
+
+	return tokenPair, "OK", nil
+}
+
+// revokeReusedRefreshToken revokes the family of a refresh token that was used again because it was probably stolen,
+// so neither the thief nor the user can keep using the family
+func (s *storageService) revokeReusedRefreshToken(row RefreshToken) (string, error) {
+	statusCode, err := s.transaction(func(session *xorm.Session) (string, error) {
+		return s.revokeTokenFamily(session, row.FamilyID)
+	})
+	if statusCode != "OK" || err != nil {
+		return statusCode, err
+	}
+
+	return "REUSED_REFRESHTOKEN", nil
+}
+
+// revokeTokenFamily revokes the RefreshTokens and deletes the access tokens of the token family
+func (s *storageService) revokeTokenFamily(session *xorm.Session, familyID string) (string, error) {
+	now := time.Now()
+	_, err := session.Where("FamilyID = ? AND RevokedAt IS NULL", familyID).Update(&RefreshToken{RevokedAt: &now})
+	if err != nil {
+		s.logError("Failed to update data in table RefreshToken:\n" + err.Error())
+		return "ERROR_UPDATING_REFRESHTOKEN", err
+	}
+
+	_, err = session.Where("FamilyID = ?", familyID).Delete(&UserToken{})
+	if err != nil {
+		s.logError("Failed to delete data from table UserToken:\n" + err.Error())
+		return "ERROR_DELETING_USERTOKEN", err
+	}
+
+	return "OK", nil
+}
+
+// CheckSecurityTokenExists checks whether the given security token exists in the database and records that its session
+// was used, revoked tokens are deleted
+func (s *storageService) CheckSecurityTokenExists(tokenString string) (bool, string, error) {
+	tokenExists, err := s.URLShortenerDB.Table(&UserToken{}).Where("Token = ?", []byte(tokenString)).Exist()
+	if err != nil {
+		s.logError("Failed to fetch UserToken data:\n" + err.Error())
 		return false, "ERROR_FETCHING_USERTOKEN", err
 	}
 	if !tokenExists {
 		return false, "NON_EXISTING_USERTOKEN", nil
 	}
 
+	// The last use is only updated once per interval so not every request writes to the database
+	now := time.Now()
+	_, err = s.URLShortenerDB.Where("Token = ? AND (LastUsedAt IS NULL OR LastUsedAt < ?)", []byte(tokenString), s.databaseTime(now.Add(-sessionLastUsedInterval))).Update(&UserToken{LastUsedAt: &now})
+	if err != nil {
+		s.logError("Failed to update data in table UserToken:\n" + err.Error())
+		return false, "ERROR_UPDATING_USERTOKEN", err
+	}
+
 	return true, "OK", nil
 }
 
-// DeleteSecurityToken deletes the given security token from the database
-func DeleteSecurityToken(token string) (string, error) {
-	tokenExists, statusCode, err := CheckSecurityTokenExists(token)
-	if statusCode != "OK" || err != nil || !tokenExists {
-		return statusCode, err
+// getUserToken returns the UserToken of an access token with the details of its session
+func (s *storageService) getUserToken(db xorm.Interface, token string) (UserToken, bool, error) {
+	var userToken UserToken
+	tokenExists, err := db.Where("Token = ?", []byte(token)).Get(&userToken)
+	if err != nil {
+		s.logError("Failed to fetch UserToken data:\n" + err.Error())
+		return UserToken{}, false, err
 	}
 
-	var userToken UserToken
-	_, err = storeService.URLShortenerDB.Table(&userToken).Where("Token = ?", token).Get(&userToken)
+	return userToken, tokenExists, nil
+}
+
+// RevokeSecurityToken revokes the token family of the given access token
+func (s *storageService) RevokeSecurityToken(token string) (string, error) {
+	userToken, tokenExists, err := s.getUserToken(s.URLShortenerDB, token)
 	if err != nil {
-		logError("Failed to fetch UserToken data:\n" + err.Error())
 		return "ERROR_FETCHING_USERTOKEN", err
 	}
+	if !tokenExists {
+		return "NON_EXISTING_USERTOKEN", nil
+	}
+	if userToken.FamilyID == "" {
+		return s.deleteSecurityToken(s.URLShortenerDB, token)
+	}
+
+	return s.transaction(func(session *xorm.Session) (string, error) {
+		return s.revokeTokenFamily(session, userToken.FamilyID)
+	})
+}
+
+// RevokeRefreshToken revokes the token family of the given refresh token
+func (s *storageService) RevokeRefreshToken(refreshToken string) (string, error) {
+	var row RefreshToken
+	refreshTokenExists, err := s.URLShortenerDB.Table(&row).Select("FamilyID").Where("TokenHash = ?", hashToken(refreshToken)).Get(&row)
+	if err != nil {
+		s.logError("Failed to fetch RefreshToken data:\n" + err.Error())
+		return "ERROR_FETCHING_REFRESHTOKEN", err
+	}
+	if !refreshTokenExists {
+		return "NON_EXISTING_REFRESHTOKEN", nil
+	}
+
+	return s.transaction(func(session *xorm.Session) (string, error) {
+		return s.revokeTokenFamily(session, row.FamilyID)
+	})
+}
 
-	_, err = storeService.URLShortenerDB.Delete(&userToken)
+// GetUserSessions returns the sessions of the user that can still be refreshed, the session of the current token is
+// marked as current
+func (s *storageService) GetUserSessions(userID string, currentToken string) ([]Session, string, error) {
+	var userTokens []UserToken
+	err := s.URLShortenerDB.Table(&UserToken{}).Select("FamilyID, CreatedAt, LastUsedAt, UserAgent, IP").
+		Where("UserID = ?", userID).
+		And("FamilyID IN (SELECT FamilyID FROM RefreshToken WHERE UserID = ? AND UsedAt IS NULL AND RevokedAt IS NULL AND ExpiresAt > ?)", userID, s.databaseTime(time.Now())).
+		OrderBy("CreatedAt").
+		Find(&userTokens)
 	if err != nil {
-		logError("Failed to delete data from table UserToken:\n" + err.Error())
+		s.logError("Failed to fetch UserToken data:\n" + err.Error())
+		return nil, "ERROR_FETCHING_USERTOKEN", err
+	}
+
+	currentUserToken, _, err := s.getUserToken(s.URLShortenerDB, currentToken)
+	if err != nil {
+		return nil, "ERROR_FETCHING_USERTOKEN", err
+	}
+
+	sessions := []Session{}
+	for _, userToken := range userTokens {
+		sessions = append(sessions, Session{
+			ID:         userToken.FamilyID,
+			CreatedAt:  userToken.CreatedAt,
+			LastUsedAt: userToken.LastUsedAt,
+			UserAgent:  userToken.UserAgent,
+			IP:         userToken.IP,
+			Current:    userToken.FamilyID == currentUserToken.FamilyID,
+		})
+	}
+
+	return sessions, "OK", nil
+}
+
+// RevokeUserSession revokes the token family of a session of the user
+func (s *storageService) RevokeUserSession(userID string, sessionID string) (string, error) {
+	sessionExists, err := s.URLShortenerDB.Table(&RefreshToken{}).Where("UserID = ? AND FamilyID = ? AND RevokedAt IS NULL", userID, sessionID).Exist()
+	if err != nil {
+		s.logError("Failed to fetch RefreshToken data:\n" + err.Error())
+		return "ERROR_FETCHING_REFRESHTOKEN", err
+	}
+	if !sessionExists {
+		return "NON_EXISTING_SESSION", nil
+	}
+
+	return s.transaction(func(session *xorm.Session) (string, error) {
+		return s.revokeTokenFamily(session, sessionID)
+	})
+}
+
+// deleteEndedSessions deletes the UserTokens and RefreshTokens of the token families of the user that can't be
+// refreshed anymore because they were revoked or expired
+func (s *storageService) deleteEndedSessions(session *xorm.Session, userID string) (string, error) {
+	var activeFamilyIDs []string
+	err := session.Table(&RefreshToken{}).Select("FamilyID").
+		Where("UserID = ? AND UsedAt IS NULL AND RevokedAt IS NULL AND ExpiresAt > ?", userID, s.databaseTime(time.Now())).
+		Find(&activeFamilyIDs)
+	if err != nil {
+		s.logError("Failed to fetch RefreshToken data:\n" + err.Error())
+		return "ERROR_FETCHING_REFRESHTOKEN", err
+	}
+
+	// The tokens from before the token families don't have a family and expired as well
+	condition := "UserID = ?"
+	args := []interface{}{userID}
+	if len(activeFamilyIDs) > 0 {
+		condition += " AND (FamilyID IS NULL OR FamilyID NOT IN (?" + strings.Repeat(", ?", len(activeFamilyIDs)-1) + "))"
+		for _, familyID := range activeFamilyIDs {
+			args = append(args, familyID)
+		}
+	}
+
+	_, err = session.Where(condition, args...).Delete(&RefreshToken{})
+	if err != nil {
+		s.logError("Failed to delete data from table RefreshToken:\n" + err.Error())
+		return "ERROR_DELETING_REFRESHTOKEN", err
+	}
+
+	_, err = session.Where(condition, args...).Delete(&UserToken{})
+	if err != nil {
+		s.logError("Failed to delete data from table UserToken:\n" + err.Error())
+		return "ERROR_DELETING_USERTOKEN", err
+	}
+
+	return "OK", nil
+}
+
+func (s *storageService) deleteSecurityToken(db xorm.Interface, token string) (string, error) {
+	deleted, err := db.Where("Token = ?", []byte(token)).Delete(&UserToken{})
+	if err != nil {
+		s.logError("Failed to delete data from table UserToken:\n" + err.Error())
 		return "ERROR_DELETING_USERTOKEN", err
 	}
+	if deleted == 0 {
+		return "NON_EXISTING_USERTOKEN", nil
+	}
+
+	return "OK", nil
+}
+
+// SaveAPIKey creates a random key for the APIKey and saves its hash in the database
+func (s *storageService) SaveAPIKey(apiKey APIKey) (APIKey, string, string, error) {
+	randomToken, err := generateRandomToken()
+	if err != nil {
+		s.logError("Failed to create API key:\n" + err.Error())
+		return APIKey{}, "", "ERROR_CREATING_APIKEY", err
+	}
+	key := APIKeyPrefix + randomToken
+
+	apiKey.ID = uuid.NewV4().String()
+	apiKey.KeyHash = hashToken(key)
+	apiKey.Prefix = key[:apiKeyPrefixLength]
+	apiKey.CreatedAt = time.Now()
+	apiKey.LastUsedAt = nil
+
+	_, err = s.URLShortenerDB.Insert(&apiKey)
+	if err != nil {
+		s.logError("Failed to insert data into table APIKey:\n" + err.Error())
+		return APIKey{}, "", "ERROR_INSERTING_APIKEY", err
+	}
+
+	return apiKey, key, "OK", nil
+}
+
+// CheckAPIKey returns the APIKey of the key if it exists and didn't expire and records that it was used
+func (s *storageService) CheckAPIKey(key string) (APIKey, string, error) {
+	var apiKey APIKey
+	apiKeyExists, err := s.URLShortenerDB.Where("KeyHash = ?", hashToken(key)).Get(&apiKey)
+	if err != nil {
+		s.logError("Failed to fetch APIKey data:\n" + err.Error())
+		return APIKey{}, "ERROR_FETCHING_APIKEY", err
+	}
+	if !apiKeyExists {
+		return APIKey{}, "NON_EXISTING_APIKEY", nil
+	}
+
+	now := time.Now()
+	if apiKey.IsExpired(now) {
+		return APIKey{}, "EXPIRED_APIKEY", nil
+	}
+
+	// Like sessions, the last use is only updated once per interval
+	if apiKey.LastUsedAt == nil || apiKey.LastUsedAt.Before(now.Add(-sessionLastUsedInterval)) {
+		_, err = s.URLShortenerDB.Where("ID = ?", apiKey.ID).Update(&APIKey{LastUsedAt: &now})
+		if err != nil {
+			s.logError("Failed to update data in table APIKey:\n" + err.Error())
+			return APIKey{}, "ERROR_UPDATING_APIKEY", err
+		}
+		apiKey.LastUsedAt = &now
+	}
+
+	return apiKey, "OK", nil
+}
+
+// GetUserAPIKeys returns the APIKeys of the user, oldest first
+func (s *storageService) GetUserAPIKeys(userID string) ([]APIKey, string, error) {
+	apiKeys := []APIKey{}
+	err := s.URLShortenerDB.Where("UserID = ?", userID).OrderBy("CreatedAt").Find(&apiKeys)
+	if err != nil {
+		s.logError("Failed to fetch APIKey data:\n" + err.Error())
+		return nil, "ERROR_FETCHING_APIKEY", err
+	}
+
+	return apiKeys, "OK", nil
+}
+
+// DeleteAPIKey deletes an APIKey of the user so it can't be used anymore
+func (s *storageService) DeleteAPIKey(userID string, id string) (string, error) {
+	deleted, err := s.URLShortenerDB.Where("ID = ? AND UserID = ?", id, userID).Delete(&APIKey{})
+	if err != nil {
+		s.logError("Failed to delete data from table APIKey:\n" + err.Error())
+		return "ERROR_DELETING_APIKEY", err
+	}
+	if deleted == 0 {
+		return "NON_EXISTING_APIKEY", nil
+	}
 
 	return "OK", nil
 }
 
 // CheckUserExists checks if the given user ID, username or email exists in the database
-func CheckUserExists(uniqueValue string) (bool, string, error) {
+func (s *storageService) CheckUserExists(uniqueValue string) (bool, string, error) {
 	var user User
-	userExists, err := storeService.URLShortenerDB.Table(&user).Where("ID = ? OR Username = ? OR Email = ?", uniqueValue, uniqueValue, uniqueValue).Exist()
+	userExists, err := s.URLShortenerDB.Table(&user).Where("ID = ? OR Username = ? OR Email = ?", uniqueValue, uniqueValue, uniqueValue).Exist()
 	if err != nil {
-		logError("Failed to fetch User data:\n" + err.Error())
+		s.logError("Failed to fetch User data:\n" + err.Error())
 		return false, "ERROR_FETCHING_USER", err
 	}
 
 	return userExists, "OK", nil
 }
 
-// GetLongURL returns the long URL based on the short URL
-func GetLongURL(shortURL string) string {
+// GetLongURL returns the ShortenedURL of the first of the short URLs that exists, a visit is only queued when the
+// ShortenedURL didn't expire, is active, has visits left and the given password matches the password of a protected
+// ShortenedURL, the visit contains the details of the visitor
+func (s *storageService) GetLongURL(shortURLs []string, password string, visit ShortenedURLVisitsHistory) (ShortenedURL, string, error) {
+	shortenedURL, passwordProtected, shortenedURLExists, err := s.getShortenedURLByShortURL(shortURLs)
+	if err != nil {
+		return ShortenedURL{}, "ERROR_FETCHING_SHORTENEDURL", err
+	}
+	if !shortenedURLExists {
+		return ShortenedURL{}, "NON_EXISTING_SHORTENEDURL", nil
+	}
+
+	now := time.Now()
+	if shortenedURL.IsExpired(now) {
+		return shortenedURL, "EXPIRED_SHORTENEDURL", nil
+	}
+	// Checked before the password so visitors aren't asked for the password of a link that won't redirect anymore
+	if remainingVisits := shortenedURL.RemainingVisits(); remainingVisits != nil && *remainingVisits == 0 {
+		return shortenedURL, "EXHAUSTED_SHORTENEDURL", nil
+	}
+
+	if passwordProtected {
+		if password == "" {
+			return shortenedURL, "PASSWORD_REQUIRED", nil
+		}
+
+		// The cache leaves the password hash out
+		if shortenedURL.Password == "" {
+			var statusCode string
+			shortenedURL.Password, statusCode, err = s.getShortURLPasswordHash(shortenedURL.ID)
+			if statusCode != "OK" || err != nil {
+				return ShortenedURL{}, statusCode, err
+			}
+		}
+
+		err = bcrypt.CompareHashAndPassword([]byte(shortenedURL.Password), []byte(password))
+		if err != nil {
+			return shortenedURL, "WRONG_PASSWORD", nil
+		}
+	}
+
+	// Checked after the password so only visitors who know it can see the activation window
+	if shortenedURL.IsNotYetActive(now) {
+		return shortenedURL, "NOT_YET_ACTIVE_SHORTENEDURL", nil
+	}
+	if shortenedURL.IsNoLongerActive(now) {
+		return shortenedURL, "NO_LONGER_ACTIVE_SHORTENEDURL", nil
+	}
+
+	// Limited ShortenedURLs count the visit right away, the condition makes sure that concurrent visits can't go over
+	// MaxVisits
+	counted := shortenedURL.MaxVisits > 0
+	if counted {
+		result, err := s.URLShortenerDB.Exec("UPDATE ShortenedURL SET Visits = Visits + 1 WHERE ID = ? AND Visits < MaxVisits", shortenedURL.ID)
+		if err != nil {
+			s.logError("Failed to update data in table ShortenedURL:\n" + err.Error())
+			return shortenedURL, "ERROR_UPDATING_SHORTENEDURL", err
+		}
+		if updated, err := result.RowsAffected(); err == nil && updated == 0 {
+			return shortenedURL, "EXHAUSTED_SHORTENEDURL", nil
+		}
+	}
+
+	visit.ShortenedURLID = shortenedURL.ID
+	visit.VisitedAt = now
+	s.visits.record(visit, counted)
+
+	return shortenedURL, "OK", nil
+}
+
+// getShortenedURLByShortURL returns the ShortenedURL fields that GetLongURL needs for the first of the short URLs that
+// exists from the cache or the database and whether it's password protected, a cached ShortenedURL has no password
+// hash. The short URLs are cached one by one, so a change only has to invalidate the
+// short URL of the ShortenedURL. Limited ShortenedURLs aren't cached because their Visits change with every visit.
+func (s *storageService) getShortenedURLByShortURL(shortURLs []string) (ShortenedURL, bool, bool, error) {
+	cachedMissing := 0
+	for _, shortURL := range shortURLs {
+		shortenedURL, passwordProtected, ok := s.shortURLs.get(shortURL)
+		if !ok {
+			break
+		}
+		if shortenedURL.ID != "" {
+			return shortenedURL, passwordProtected, true, nil
+		}
+		cachedMissing++
+	}
+	if cachedMissing == len(shortURLs) {
+		return ShortenedURL{}, false, false, nil
+	}
+
+	args := make([]interface{}, len(shortURLs))
+	for i, shortURL := range shortURLs {
+		args[i] = shortURL
+	}
+
+	var shortenedURLs []ShortenedURL
+	err := s.URLShortenerDB.Select("ID, ShortURL, LongURL, ExpiresAt, ArchivedAt, MaxVisits, Visits, ActiveFrom, ActiveUntil, RedirectType, Password").Where("ShortURL IN (?"+strings.Repeat(", ?", len(shortURLs)-1)+")", args...).Find(&shortenedURLs)
+	if err != nil {
+		s.logError("Failed to fetch ShortenedURL data:\n" + err.Error())
+		return ShortenedURL{}, false, false, err
+	}
+
+	var found ShortenedURL
+	for _, shortURL := range shortURLs {
+		shortenedURL := matchShortURL(shortenedURLs, shortURL)
+		if shortenedURL.MaxVisits == 0 {
+			s.shortURLs.set(shortURL, shortenedURL)
+		}
+		if found.ID == "" {
+			found = shortenedURL
+		}
+	}
+
+	return found, found.Password != "", found.ID != "", nil
+}
+
+// getShortURLPasswordHash returns the password hash of a ShortenedURL that was found in the cache
+func (s *storageService) getShortURLPasswordHash(id string) (string, string, error) {
 	var shortenedURL ShortenedURL
-	_, err := storeService.URLShortenerDB.Table(&shortenedURL).Select("ID, LongURL").Where("ShortURL = ?", shortURL).Get(&shortenedURL)
+	shortenedURLExists, err := s.URLShortenerDB.Table(&shortenedURL).Select("Password").Where("ID = ?", id).Get(&shortenedURL)
 	if err != nil {
-		logError("Failed to fetch ShortenedURL data:\n" + err.Error())
+		s.logError("Failed to fetch ShortenedURL data:\n" + err.Error())
+		return "", "ERROR_FETCHING_SHORTENEDURL", err
+	}
+	if !shortenedURLExists {
+		return "", "NON_EXISTING_SHORTENEDURL", nil
 	}
 
-	var visitsHistory = ShortenedURLVisitsHistory{
-		ShortenedURLID: shortenedURL.ID,
+	return shortenedURL.Password, "OK", nil
+}
+
+// matchShortURL returns the ShortenedURL with the short URL, or one with the short URL in another case when the
+// database compares case-insensitively like MySQL does by default. A ShortenedURL without an ID means none matches.
+func matchShortURL(shortenedURLs []ShortenedURL, shortURL string) ShortenedURL {
+	for _, shortenedURL := range shortenedURLs {
+		if shortenedURL.ShortURL == shortURL {
+			return shortenedURL
+		}
 	}
 
-	_, err = storeService.URLShortenerDB.Insert(&visitsHistory)
+	for _, shortenedURL := range shortenedURLs {
+		if strings.EqualFold(shortenedURL.ShortURL, shortURL) {
+			return shortenedURL
+		}
+	}
+
+	return ShortenedURL{}
+}
+
+// GetShortenedURLVisitStats counts the visits of a ShortenedURL from (inclusive) to (exclusive) per hour, day or week,
+// the counting is done by the database so the visits don't have to be fetched
+func (s *storageService) GetShortenedURLVisitStats(id string, from time.Time, to time.Time, interval string) (VisitStats, string, error) {
+	stats := VisitStats{
+		From:     from,
+		To:       to,
+		Interval: interval,
+		Buckets:  []VisitStatsBucket{},
+	}
+
+	const counts = "COUNT(*) AS Visits, COUNT(DISTINCT NULLIF(IPHash, '')) AS UniqueVisitors"
+	const visits = " FROM ShortenedURLVisitsHistory WHERE ShortenedURLID = ? AND VisitedAt >= ? AND VisitedAt < ?"
+
+	var rows []visitStatsRow
+	err := s.URLShortenerDB.SQL("SELECT "+s.dialect.timeBucket("VisitedAt", interval)+" AS Bucket, "+counts+visits+" GROUP BY 1 ORDER BY 1", id, s.databaseTime(from), s.databaseTime(to)).Find(&rows)
+	if err != nil {
+		s.logError("Failed to aggregate ShortenedURLVisitsHistory data:\n" + err.Error())
+		return VisitStats{}, "ERROR_FETCHING_ANALYTICS", err
+	}
+
+	for _, row := range rows {
+		// The buckets are in the timezone that the visits were stored in
+		start, err := time.ParseInLocation(visitStatsBucketLayout, row.Bucket, s.URLShortenerDB.DatabaseTZ)
+		if err != nil {
+			s.logError("Failed to parse ShortenedURLVisitsHistory bucket:\n" + err.Error())
+			return VisitStats{}, "ERROR_FETCHING_ANALYTICS", err
+		}
+
+		stats.Buckets = append(stats.Buckets, VisitStatsBucket{
+			Start:          start.In(s.URLShortenerDB.TZLocation),
+			Visits:         row.Visits,
+			UniqueVisitors: row.UniqueVisitors,
+		})
+	}
+
+	// The unique visitors of the buckets can't be added up because a visitor can come back in another bucket
+	var totals visitStatsRow
+	_, err = s.URLShortenerDB.SQL("SELECT "+counts+visits, id, s.databaseTime(from), s.databaseTime(to)).Get(&totals)
 	if err != nil {
-		logError("Failed to insert data into table ShortenedURLVisitsHistory:\n" + err.Error())
+		s.logError("Failed to aggregate ShortenedURLVisitsHistory data:\n" + err.Error())
+		return VisitStats{}, "ERROR_FETCHING_ANALYTICS", err
 	}
+	stats.TotalVisits = totals.Visits
+	stats.UniqueVisitors = totals.UniqueVisitors
 
-	return shortenedURL.LongURL
+	return stats, "OK", nil
 }
 
-// SaveURL inserts a ShortenedURL object and a UserShortenedURL object into the database
-func SaveURL(shortURL string, name string, longURL string, userID string) (ShortenedURL, string, error) {
-	userExists, statusCode, err := CheckUserExists(userID)
+// SaveURL inserts the given shortenedURL object with a new ID and a hashed password and a UserShortenedURL object into
+// the database
+func (s *storageService) SaveURL(shortenedURL ShortenedURL, userID string) (ShortenedURL, string, error) {
+	userExists, statusCode, err := s.CheckUserExists(userID)
 	if statusCode != "OK" || err != nil {
 		return ShortenedURL{}, statusCode, err
 	} else if !userExists {
@@ -321,44 +985,110 @@ func SaveURL(shortURL string, name string, longURL string, userID string) (Short
 	}
 
 	id := uuid.NewV4().String()
+	shortenedURL.ID = id
 
-	var shortenedURL = ShortenedURL{
-		ID:       id,
-		Name:     name,
-		ShortURL: shortURL,
-		LongURL:  longURL,
+	if shortenedURL.Password != "" {
+		hash, err := generateShortURLPasswordHash(shortenedURL.Password)
+		if err != nil {
+			s.logError("Failed to generate password hash:\n" + err.Error())
+			return ShortenedURL{}, "ERROR_GENERATING_HASH", err
+		}
+		shortenedURL.Password = hash
 	}
 
-	_, err = storeService.URLShortenerDB.Insert(&shortenedURL)
-	if err != nil {
-		if strings.Contains(err.Error(), "Error 1062") {
-			return ShortenedURL{}, "DUPLICATE_URL", err
+	statusCode, err = s.transaction(func(session *xorm.Session) (string, error) {
+		_, err := session.Insert(&shortenedURL)
+		if err != nil {
+			err = s.dialect.classifyError(err)
+			if errors.Is(err, ErrDuplicate) {
+				return "DUPLICATE_URL", err
+			}
+
+			s.logError("Failed to insert data into table ShortenedURL:\n" + err.Error())
+			return "ERROR_INSERTING_SHORTENEDURL", err
 		}
 
-		logError("Failed to insert data into table ShortenedURL:\n" + err.Error())
-		return ShortenedURL{}, "ERROR_INSERTING_SHORTENEDURL", err
-	}
+		var userShortenedURL = UserShortenedURL{
+			UserID:         userID,
+			ShortenedURLID: id,
+		}
 
-	var userShortenedURL = UserShortenedURL{
-		UserID:         userID,
-		ShortenedURLID: id,
-	}
+		_, err = session.Insert(&userShortenedURL)
+		if err != nil {
+			s.logError("Failed to insert url data into table UserShortenedURL:\n" + err.Error())
+			return "ERROR_INSERTING_USERSHORTENEDURL", err
+		}
 
-	_, err = storeService.URLShortenerDB.Insert(&userShortenedURL)
-	if err != nil {
-		logError("Failed to insert url data into table UserShortenedURL:\n" + err.Error())
-		return ShortenedURL{}, "ERROR_INSERTING_USERSHORTENEDURL", err
+		return "OK", nil
+	})
+	if statusCode != "OK" || err != nil {
+		return ShortenedURL{}, statusCode, err
 	}
 
+	// The short URL could be cached as unknown
+	s.shortURLs.invalidate(shortenedURL.ShortURL)
+
 	now := time.Now()
 	shortenedURL.CreatedAt = now
 
 	return shortenedURL, "OK", nil
 }
 
-// UpdateShortenedURL updates the given shortenedURL object in the database
-func UpdateShortenedURL(shortenedURL ShortenedURL) (string, error) {
-	shortenedURLExists, err := checkShortenedURLExists(shortenedURL.ID)
+// NextShortURLCounter increments the ShortURLCounter and returns its new value, concurrent calls from any replica get
+// different values
+func (s *storageService) NextShortURLCounter() (uint64, string, error) {
+	var counter ShortURLCounter
+	statusCode, err := s.transaction(func(session *xorm.Session) (string, error) {
+		// Incrementing before reading locks the row until the transaction ends
+		_, err := session.Exec("UPDATE ShortURLCounter SET LastValue = LastValue + 1 WHERE ID = ?", shortURLCounterID)
+		if err != nil {
+			s.logError("Failed to update data in table ShortURLCounter:\n" + err.Error())
+			return "ERROR_UPDATING_SHORTURLCOUNTER", err
+		}
+
+		counterExists, err := session.ID(shortURLCounterID).Get(&counter)
+		if err != nil {
+			s.logError("Failed to fetch ShortURLCounter data:\n" + err.Error())
+			return "ERROR_FETCHING_SHORTURLCOUNTER", err
+		}
+		if !counterExists {
+			return "NON_EXISTING_SHORTURLCOUNTER", errors.New("the ShortURLCounter row is missing, run the migrations")
+		}
+
+		return "OK", nil
+	})
+	if statusCode != "OK" || err != nil {
+		return 0, statusCode, err
+	}
+
+	return uint64(counter.LastValue), "OK", nil
+}
+
+// GetUserShortenedURLByLongURL returns the oldest ShortenedURL of the user that redirects to the given long URL and
+// didn't expire or run out of visits
+func (s *storageService) GetUserShortenedURLByLongURL(userID string, longURL string) (ShortenedURL, string, error) {
+	var shortenedURL ShortenedURL
+	shortenedURLExists, err := s.URLShortenerDB.Table(&shortenedURL).
+		Where("LongURL = ? AND ID IN (SELECT ShortenedURLID FROM UserShortenedURL WHERE UserID = ?)", longURL, userID).
+		And("ArchivedAt IS NULL AND (ExpiresAt IS NULL OR ExpiresAt > ?) AND (MaxVisits = 0 OR Visits < MaxVisits)", s.databaseTime(time.Now())).
+		OrderBy("CreatedAt").
+		Get(&shortenedURL)
+	if err != nil {
+		s.logError("Failed to fetch ShortenedURL data:\n" + err.Error())
+		return ShortenedURL{}, "ERROR_FETCHING_SHORTENEDURL", err
+	}
+	if !shortenedURLExists {
+		return ShortenedURL{}, "NON_EXISTING_SHORTENEDURL", nil
+	}
+
+	return shortenedURL, "OK", nil
+}
+
+// UpdateShortenedURL updates the given shortenedURL object with a hashed password in the database and saves the previous
+// long URL in a ShortenedURLRevision when it changes, XORM skips empty fields so the clear columns are explicitly set to
+// NULL or their zero value
+func (s *storageService) UpdateShortenedURL(shortenedURL ShortenedURL, changedBy string, clearColumns ...string) (string, error) {
+	shortURL, shortenedURLExists, err := s.getShortURL(shortenedURL.ID)
 	if err != nil {
 		return "ERROR_FETCHING_SHORTENEDURL", err
 	}
@@ -366,25 +1096,95 @@ func UpdateShortenedURL(shortenedURL ShortenedURL) (string, error) {
 		return "NON_EXISTING_SHORTENEDURL", nil
 	}
 
-	_, err = storeService.URLShortenerDB.ID(shortenedURL.ID).Update(&shortenedURL)
-	if err != nil {
-		logError("Failed to update data in table ShortenedURL:\n" + err.Error())
-		return "ERROR_UPDATING_SHORTENEDURL", err
+	if shortenedURL.Password != "" {
+		hash, err := generateShortURLPasswordHash(shortenedURL.Password)
+		if err != nil {
+			s.logError("Failed to generate password hash:\n" + err.Error())
+			return "ERROR_GENERATING_HASH", err
+		}
+		shortenedURL.Password = hash
 	}
 
+	statusCode, err := s.transaction(func(session *xorm.Session) (string, error) {
+		var currentURL ShortenedURL
+		_, err := session.Table(&currentURL).Select("LongURL, ActiveFrom, ActiveUntil").Where("ID = ?", shortenedURL.ID).Get(&currentURL)
+		if err != nil {
+			s.logError("Failed to fetch ShortenedURL data:\n" + err.Error())
+			return "ERROR_FETCHING_SHORTENEDURL", err
+		}
+
+		// A new start or end of the activation window has to fit the stored other end
+		activeFrom, activeUntil := currentURL.ActiveFrom, currentURL.ActiveUntil
+		if shortenedURL.ActiveFrom != nil {
+			activeFrom = shortenedURL.ActiveFrom
+		}
+		if shortenedURL.ActiveUntil != nil {
+			activeUntil = shortenedURL.ActiveUntil
+		}
+		if activeFrom != nil && activeUntil != nil && !activeFrom.Before(*activeUntil) {
+			return "INVALID_SCHEDULE", nil
+		}
+
+		if shortenedURL.LongURL != "" && currentURL.LongURL != shortenedURL.LongURL {
+			var revision = ShortenedURLRevision{
+				ID:              uuid.NewV4().String(),
+				ShortenedURLID:  shortenedURL.ID,
+				PreviousLongURL: currentURL.LongURL,
+				NewLongURL:      shortenedURL.LongURL,
+				ChangedBy:       changedBy,
+			}
+
+			_, err = session.Insert(&revision)
+			if err != nil {
+				s.logError("Failed to insert data into table ShortenedURLRevision:\n" + err.Error())
+				return "ERROR_INSERTING_SHORTENEDURLREVISION", err
+			}
+		}
+
+		_, err = session.ID(shortenedURL.ID).Nullable(clearColumns...).MustCols(clearColumns...).Update(&shortenedURL)
+		if err != nil {
+			s.logError("Failed to update data in table ShortenedURL:\n" + err.Error())
+			return "ERROR_UPDATING_SHORTENEDURL", err
+		}
+
+		return "OK", nil
+	})
+	if statusCode != "OK" || err != nil {
+		return statusCode, err
+	}
+
+	s.shortURLs.invalidate(shortURL)
+
 	return "OK", nil
 }
 
-// DeleteShortenedURL deletes the given shortenedURL object in the database
-func DeleteShortenedURL(id string) (string, error) {
-	var shortenedURL ShortenedURL
-	_, err := storeService.URLShortenerDB.Table(&shortenedURL).Where("ID = ?", id).Get(&shortenedURL)
+// ArchiveExpiredShortenedURLs sets ArchivedAt on the ShortenedURLs that expired and weren't archived yet
+func (s *storageService) ArchiveExpiredShortenedURLs() (int64, string, error) {
+	now := time.Now()
+
+	archived, err := s.URLShortenerDB.Where("ExpiresAt <= ? AND ArchivedAt IS NULL", s.databaseTime(now)).Update(&ShortenedURL{ArchivedAt: &now})
 	if err != nil {
-		logError("Failed to fetch ShortenedURL data:\n" + err.Error())
-		return "ERROR_FETCHING_SHORTENEDURL", err
+		s.logError("Failed to archive expired data in table ShortenedURL:\n" + err.Error())
+		return 0, "ERROR_ARCHIVING_SHORTENEDURLS", err
+	}
+
+	return archived, "OK", nil
+}
+
+// CheckShortenedURLOwner checks in table UserShortenedURL whether the given user created the ShortenedURL
+func (s *storageService) CheckShortenedURLOwner(userID string, id string) (bool, string, error) {
+	isOwner, err := s.URLShortenerDB.Table(&UserShortenedURL{}).Where("UserID = ? AND ShortenedURLID = ?", userID, id).Exist()
+	if err != nil {
+		s.logError("Failed to fetch UserShortenedURL data:\n" + err.Error())
+		return false, "ERROR_FETCHING_USERSHORTENEDURL", err
 	}
 
-	shortenedURLExists, err := checkShortenedURLExists(id)
+	return isOwner, "OK", nil
+}
+
+// DeleteShortenedURL deletes the given shortenedURL object in the database
+func (s *storageService) DeleteShortenedURL(id string) (string, error) {
+	shortURL, shortenedURLExists, err := s.getShortURL(id)
 	if err != nil {
 		return "ERROR_FETCHING_SHORTENEDURL", err
 	}
@@ -392,48 +1192,52 @@ func DeleteShortenedURL(id string) (string, error) {
 		return "NON_EXISTING_SHORTENEDURL", nil
 	}
 
-	_, err = storeService.URLShortenerDB.Delete(&shortenedURL)
-	if err != nil {
-		logError("Failed to delete data from table ShortenedURL:\n" + err.Error())
-		return "ERROR_DELETING_SHORTENEDURL", err
+	statusCode, err := s.transaction(func(session *xorm.Session) (string, error) {
+		return s.deleteShortenedURL(session, id)
+	})
+	if statusCode != "OK" || err != nil {
+		return statusCode, err
 	}
 
-	var usershortenedURL UserShortenedURL
-	_, err = storeService.URLShortenerDB.Table(&usershortenedURL).Where("ShortenedURLID = ?", id).Get(&usershortenedURL)
+	s.shortURLs.invalidate(shortURL)
+
+	return "OK", nil
+}
+
+// deleteShortenedURL deletes a ShortenedURL with its UserShortenedURL and analytics (ShortenedURLVisitsHistory)
+func (s *storageService) deleteShortenedURL(session *xorm.Session, id string) (string, error) {
+	_, err := session.Delete(&ShortenedURL{ID: id})
 	if err != nil {
-		logError("Failed to fetch UserShortenedURL data:\n" + err.Error())
-		return "ERROR_FETCHING_USERSHORTENEDURL", err
+		s.logError("Failed to delete data from table ShortenedURL:\n" + err.Error())
+		return "ERROR_DELETING_SHORTENEDURL", err
 	}
 
-	_, err = storeService.URLShortenerDB.Delete(&usershortenedURL)
+	_, err = session.Delete(&UserShortenedURL{ShortenedURLID: id})
 	if err != nil {
-		logError("Failed to delete data from table UserShortenedURL:\n" + err.Error())
+		s.logError("Failed to delete data from table UserShortenedURL:\n" + err.Error())
 		return "ERROR_DELETING_USERSHORTENEDURL", err
 	}
 
-	var analytics []ShortenedURLVisitsHistory
-	err = storeService.URLShortenerDB.Table(&ShortenedURLVisitsHistory{}).Find(&analytics, &ShortenedURLVisitsHistory{ShortenedURLID: id})
+	_, err = session.Delete(&ShortenedURLVisitsHistory{ShortenedURLID: id})
 	if err != nil {
-		logError("Failed to fetch ShortenedURLVisitsHistory data:\n" + err.Error())
-		return "ERROR_FETCHING_SHORTENEDURLVISITSHISTORY", err
+		s.logError("Failed to delete data from table ShortenedURLVisitsHistory:\n" + err.Error())
+		return "ERROR_DELETING_SHORTENEDURLVISITSHISTORY", err
 	}
 
-	for _, item := range analytics {
-		_, err = storeService.URLShortenerDB.Delete(&item)
-		if err != nil {
-			logError("Failed to delete data from table ShortenedURLVisitsHistory:\n" + err.Error())
-			return "ERROR_DELETING_SHORTENEDURLVISITSHISTORY", err
-		}
+	_, err = session.Delete(&ShortenedURLRevision{ShortenedURLID: id})
+	if err != nil {
+		s.logError("Failed to delete data from table ShortenedURLRevision:\n" + err.Error())
+		return "ERROR_DELETING_SHORTENEDURLREVISION", err
 	}
 
 	return "OK", nil
 }
 
 // GetUserShortenedURLs returns all ShortenedURL objects with analytics that a user created.
-func GetUserShortenedURLs(userID string) ([]ShortenedURLData, string, error) {
+func (s *storageService) GetUserShortenedURLs(userID string) ([]ShortenedURLData, string, error) {
 	var shortenedURLData []ShortenedURLData
 
-	userExists, statusCode, err := CheckUserExists(userID)
+	userExists, statusCode, err := s.CheckUserExists(userID)
 	if statusCode != "OK" || err != nil {
 		return shortenedURLData, statusCode, err
 	} else if !userExists {
@@ -442,9 +1246,9 @@ func GetUserShortenedURLs(userID string) ([]ShortenedURLData, string, error) {
 
 	// Get the ShortenedURLIDs by userID from table UserShortenedURL
 	var userShortenedURLs []UserShortenedURL
-	err = storeService.URLShortenerDB.Table(&UserShortenedURL{}).Select("ShortenedURLID").Find(&userShortenedURLs, &UserShortenedURL{UserID: userID})
+	err = s.URLShortenerDB.Table(&UserShortenedURL{}).Select("ShortenedURLID").Find(&userShortenedURLs, &UserShortenedURL{UserID: userID})
 	if err != nil {
-		logError("Failed to fetch UserShortenedURL data:\n" + err.Error())
+		s.logError("Failed to fetch UserShortenedURL data:\n" + err.Error())
 		return shortenedURLData, "ERROR_FETCHING_USERSHORTENEDURL", err
 	}
 
@@ -455,20 +1259,22 @@ func GetUserShortenedURLs(userID string) ([]ShortenedURLData, string, error) {
 			data         ShortenedURLData
 			shortenedURL ShortenedURL
 		)
-		_, err = storeService.URLShortenerDB.Table(&shortenedURL).Where("ID = ?", userShortenedURL.ShortenedURLID).Get(&shortenedURL)
+		_, err = s.URLShortenerDB.Table(&shortenedURL).Where("ID = ?", userShortenedURL.ShortenedURLID).Get(&shortenedURL)
 		if err == nil {
 			data.ShortenedURLObject = shortenedURL
+			data.RemainingVisits = shortenedURL.RemainingVisits()
+			data.PasswordProtected = shortenedURL.Password != ""
 		} else {
-			logError("Failed to fetch ShortenedURL data:\n" + err.Error())
+			s.logError("Failed to fetch ShortenedURL data:\n" + err.Error())
 			return []ShortenedURLData{}, "ERROR_FETCHING_SHORTENEDURL", err
 		}
 
 		var urlAnalytics []string
-		err := storeService.URLShortenerDB.Table(&ShortenedURLVisitsHistory{}).Select("VisitedAt").Find(&urlAnalytics, &ShortenedURLVisitsHistory{ShortenedURLID: data.ShortenedURLObject.ID})
+		err := s.URLShortenerDB.Table(&ShortenedURLVisitsHistory{}).Select("VisitedAt").Find(&urlAnalytics, &ShortenedURLVisitsHistory{ShortenedURLID: data.ShortenedURLObject.ID})
 		if err == nil {
 			data.Analytics = urlAnalytics
-		} else if !strings.Contains(err.Error(), "Error 1054") {
-			logError("Failed to fetch ShortenedURLVisitsHistory data:\n" + err.Error())
+		} else if !errors.Is(s.dialect.classifyError(err), ErrMissingColumn) {
+			s.logError("Failed to fetch ShortenedURLVisitsHistory data:\n" + err.Error())
 			return []ShortenedURLData{}, "ERROR_FETCHING_ANALYTICS", err
 		}
 
@@ -479,37 +1285,43 @@ func GetUserShortenedURLs(userID string) ([]ShortenedURLData, string, error) {
 }
 
 // SaveUser inserts a User object into the database
-func SaveUser(user User) (string, string, string, error) {
+func (s *storageService) SaveUser(user User, client SessionClient) (TokenPair, string, string, error) {
 	user.ID = uuid.NewV4().String()
 
 	hash, err := generatePasswordHash(user.Password)
 	if err != nil {
-		logError("Failed to generate password hash:\n" + err.Error())
-		return "", "", "ERROR_GENERATING_HASH", err
+		s.logError("Failed to generate password hash:\n" + err.Error())
+		return TokenPair{}, "", "ERROR_GENERATING_HASH", err
 	}
 	user.Password = hash
 
-	_, err = storeService.URLShortenerDB.Insert(&user)
-	if err != nil {
-		if strings.Contains(err.Error(), "Error 1062") {
-			return "", "", "DUPLICATE_USER", err
-		}
+	var tokenPair TokenPair
+	statusCode, err := s.transaction(func(session *xorm.Session) (string, error) {
+		_, err := session.Insert(&user)
+		if err != nil {
+			err = s.dialect.classifyError(err)
+			if errors.Is(err, ErrDuplicate) {
+				return "DUPLICATE_USER", err
+			}
 
-		logError("Failed to insert data into table User:\n" + err.Error())
-		return "", "", "ERROR_INSERTING_USER", err
-	}
+			s.logError("Failed to insert data into table User:\n" + err.Error())
+			return "ERROR_INSERTING_USER", err
+		}
 
-	token, statusCode, err := GenerateSecurityToken(user)
+		var statusCode string
+		tokenPair, statusCode, err = s.generateTokenPair(session, user, newSessionToken(client))
+		return statusCode, err
+	})
 	if statusCode != "OK" || err != nil {
-		return "", "", statusCode, err
+		return TokenPair{}, "", statusCode, err
 	}
 
-	return token, user.ID, "OK", nil
+	return tokenPair, user.ID, "OK", nil
 }
 
 // GetUser returns a User object by ID, username or email
-func GetUser(uniqueValue string) (User, string, error) {
-	userExists, statusCode, err := CheckUserExists(uniqueValue)
+func (s *storageService) GetUser(uniqueValue string) (User, string, error) {
+	userExists, statusCode, err := s.CheckUserExists(uniqueValue)
 	if statusCode != "OK" || err != nil {
 		return User{}, statusCode, err
 	} else if !userExists {
@@ -517,9 +1329,9 @@ func GetUser(uniqueValue string) (User, string, error) {
 	}
 
 	var user User
-	_, err = storeService.URLShortenerDB.Table(&user).Select("ID, FirstName, LastName, Username, Email").Where("ID = ? OR Username = ? OR Email = ?", uniqueValue, uniqueValue, uniqueValue).Get(&user)
+	_, err = s.URLShortenerDB.Table(&user).Select("ID, FirstName, LastName, Username, Email").Where("ID = ? OR Username = ? OR Email = ?", uniqueValue, uniqueValue, uniqueValue).Get(&user)
 	if err != nil {
-		logError("Failed to fetch User data:\n" + err.Error())
+		s.logError("Failed to fetch User data:\n" + err.Error())
 		return User{}, "ERROR_FETCHING_USER", err
 	}
 
@@ -527,8 +1339,8 @@ func GetUser(uniqueValue string) (User, string, error) {
 }
 
 // UpdateUser updates the given user object in the database
-func UpdateUser(user User, token string) (string, string, error) {
-	userExists, statusCode, err := CheckUserExists(user.ID)
+func (s *storageService) UpdateUser(user User, token string) (string, string, error) {
+	userExists, statusCode, err := s.CheckUserExists(user.ID)
 	if statusCode != "OK" || err != nil {
 		return "", statusCode, err
 	}
@@ -539,7 +1351,7 @@ func UpdateUser(user User, token string) (string, string, error) {
 	if user.Password != "" {
 		hash, err := generatePasswordHash(user.Password)
 		if err != nil {
-			logError("Failed to generate password hash:\n" + err.Error())
+			s.logError("Failed to generate password hash:\n" + err.Error())
 			return "", "ERROR_GENERATING_HASH", err
 		}
 
@@ -547,97 +1359,126 @@ func UpdateUser(user User, token string) (string, string, error) {
 	}
 
 	var oldUser User
-	_, err = storeService.URLShortenerDB.Table(&oldUser).Select("ID, FirstName, LastName, Username, Email").Where("ID = ?", user.ID).Get(&oldUser)
+	_, err = s.URLShortenerDB.Table(&oldUser).Select("ID, FirstName, LastName, Username, Email").Where("ID = ?", user.ID).Get(&oldUser)
 	if err != nil {
-		logError("Failed to fetch User data:\n" + err.Error())
+		s.logError("Failed to fetch User data:\n" + err.Error())
 		return "", "ERROR_FETCHING_USER", err
 	}
 
-	_, err = storeService.URLShortenerDB.ID(user.ID).Update(&user)
-	if err != nil {
-		if strings.Contains(err.Error(), "Error 1062") {
-			return "", "DUPLICATE_USER", err
-		}
-
-		logError("Failed to update data in table User:\n" + err.Error())
-		return "", "ERROR_UPDATING_USER", err
-	}
+	var newToken string
+	statusCode, err = s.transaction(func(session *xorm.Session) (string, error) {
+		_, err := session.ID(user.ID).Update(&user)
+		if err != nil {
+			err = s.dialect.classifyError(err)
+			if errors.Is(err, ErrDuplicate) {
+				return "DUPLICATE_USER", err
+			}
 
-	// Create a new token if the username was changed because the payload of the token contains the username
-	if user.Username != oldUser.Username {
-		newToken, statusCode, err := GenerateSecurityToken(user)
-		if statusCode != "OK" || err != nil {
-			return "", statusCode, err
+			s.logError("Failed to update data in table User:\n" + err.Error())
+			return "ERROR_UPDATING_USER", err
 		}
 
-		statusCode, err = DeleteSecurityToken(token)
-		if statusCode != "OK" || err != nil {
-			return "", statusCode, err
+		// Create a new token in the same token family if the username was changed because the payload of the token
+		// contains the username
+		if user.Username != oldUser.Username {
+			userToken, _, err := s.getUserToken(session, token)
+			if err != nil {
+				return "ERROR_FETCHING_USERTOKEN", err
+			}
+
+			var statusCode string
+			newToken, statusCode, err = s.generateSecurityToken(session, user, userToken)
+			if statusCode != "OK" || err != nil {
+				return statusCode, err
+			}
+
+			return s.deleteSecurityToken(session, token)
 		}
 
-		return newToken, "OK", nil
+		return "OK", nil
+	})
+	if statusCode != "OK" || err != nil {
+		return "", statusCode, err
 	}
 
-	return "", "OK", nil
+	return newToken, "OK", nil
 }
 
-// DeleteUser returns a User object by ID
-func DeleteUser(id string) (string, error) {
-	userExists, statusCode, err := CheckUserExists(id)
+// DeleteUser deletes a User by ID with its UserTokens, APIKeys, ShortenedURLs and analytics
+func (s *storageService) DeleteUser(id string) (string, error) {
+	userExists, statusCode, err := s.CheckUserExists(id)
 	if statusCode != "OK" || err != nil {
 		return statusCode, err
 	} else if !userExists {
 		return "NON_EXISTING_USER", nil
 	}
 
-	// Delete the User
-	_, err = storeService.URLShortenerDB.Delete(&User{ID: id})
-	if err != nil {
-		logError("Failed to delete data from table User:\n" + err.Error())
-		return "ERROR_DELETING_USER", err
-	}
+	// The short URLs are removed from the cache once they're deleted
+	var shortURLs []string
 
-	// Delete the UserTokens
-	_, err = storeService.URLShortenerDB.Delete(&UserToken{UserID: id})
-	if err != nil {
-		logError("Failed to delete data from table User:\n" + err.Error())
-		return "ERROR_DELETING_USER", err
-	}
+	statusCode, err = s.transaction(func(session *xorm.Session) (string, error) {
+		// Delete the User
+		_, err := session.Delete(&User{ID: id})
+		if err != nil {
+			s.logError("Failed to delete data from table User:\n" + err.Error())
+			return "ERROR_DELETING_USER", err
+		}
 
-	// Get the ShortenedURLIDs by userID from table UserShortenedURL
-	var userShortenedURLs []UserShortenedURL
-	err = storeService.URLShortenerDB.Table(&UserShortenedURL{}).Select("ShortenedURLID").Find(&userShortenedURLs, &UserShortenedURL{UserID: id})
-	if err != nil {
-		logError("Failed to fetch UserShortenedURL data:\n" + err.Error())
-		return "ERROR_FETCHING_USERSHORTENEDURL", err
-	}
+		// Delete the UserTokens and RefreshTokens
+		_, err = session.Delete(&UserToken{UserID: id})
+		if err != nil {
+			s.logError("Failed to delete data from table UserToken:\n" + err.Error())
+			return "ERROR_DELETING_USERTOKEN", err
+		}
 
-	// Delete the UserShortenedURLs, ShortenedURLs and analytics (ShortenedURLVisitsHistory)
-	for _, userShortenedURL := range userShortenedURLs {
-		_, err = storeService.URLShortenerDB.Delete(&userShortenedURL)
+		_, err = session.Delete(&RefreshToken{UserID: id})
+		if err != nil {
+			s.logError("Failed to delete data from table RefreshToken:\n" + err.Error())
+			return "ERROR_DELETING_REFRESHTOKEN", err
+		}
+
+		// Delete the APIKeys
+		_, err = session.Delete(&APIKey{UserID: id})
 		if err != nil {
-			logError("Failed to delete data from table UserShortenedURL:\n" + err.Error())
-			return "ERROR_DELETING_USERSHORTENEDURL", err
+			s.logError("Failed to delete data from table APIKey:\n" + err.Error())
+			return "ERROR_DELETING_APIKEY", err
 		}
 
-		_, err = storeService.URLShortenerDB.Delete(&ShortenedURL{ID: userShortenedURL.ShortenedURLID})
+		// Get the ShortenedURLIDs by userID from table UserShortenedURL
+		var userShortenedURLs []UserShortenedURL
+		err = session.Table(&UserShortenedURL{}).Select("ShortenedURLID").Find(&userShortenedURLs, &UserShortenedURL{UserID: id})
 		if err != nil {
-			logError("Failed to delete data from table UserShortenedURL:\n" + err.Error())
-			return "ERROR_DELETING_SHORTENEDURL", err
+			s.logError("Failed to fetch UserShortenedURL data:\n" + err.Error())
+			return "ERROR_FETCHING_USERSHORTENEDURL", err
 		}
 
-		_, err = storeService.URLShortenerDB.Delete(&ShortenedURLVisitsHistory{ShortenedURLID: userShortenedURL.ShortenedURLID})
+		err = session.Table(&ShortenedURL{}).Select("ShortURL").Where("ID IN (SELECT ShortenedURLID FROM UserShortenedURL WHERE UserID = ?)", id).Find(&shortURLs)
 		if err != nil {
-			logError("Failed to delete data from table ShortenedURLVisitsHistory:\n" + err.Error())
-			return "ERROR_DELETING_SHORTENEDURLVISITSHISTORY", err
+			s.logError("Failed to fetch ShortenedURL data:\n" + err.Error())
+			return "ERROR_FETCHING_SHORTENEDURL", err
 		}
+
+		// Delete the UserShortenedURLs, ShortenedURLs and analytics (ShortenedURLVisitsHistory)
+		for _, userShortenedURL := range userShortenedURLs {
+			statusCode, err := s.deleteShortenedURL(session, userShortenedURL.ShortenedURLID)
+			if statusCode != "OK" || err != nil {
+				return statusCode, err
+			}
+		}
+
+		return "OK", nil
+	})
+	if statusCode != "OK" || err != nil {
+		return statusCode, err
 	}
 
+	s.shortURLs.invalidate(shortURLs...)
+
 	return "OK", nil
 }
 
-// CheckLogin compares the given password with the password hash from the database and returns a new token if they match
-func CheckLogin(user User) (string, string, string, error) {
+// CheckLogin compares the given password with the password hash from the database and returns a new TokenPair if they match
+func (s *storageService) CheckLogin(user User, client SessionClient) (TokenPair, string, string, error) {
 	var uniqueValue string
 	if user.Username != "" {
 		uniqueValue = user.Username
@@ -645,29 +1486,31 @@ func CheckLogin(user User) (string, string, string, error) {
 		uniqueValue = user.Email
 	}
 
-	userExists, statusCode, err := CheckUserExists(uniqueValue)
+	userExists, statusCode, err := s.CheckUserExists(uniqueValue)
 	if statusCode != "OK" || err != nil {
-		return "", "", statusCode, err
+		return TokenPair{}, "", statusCode, err
 	} else if !userExists {
-		return "", "", "NON_EXISTING_USER", nil
+		return TokenPair{}, "", "NON_EXISTING_USER", nil
 	}
 
 	var userFromDatabase User
-	_, err = storeService.URLShortenerDB.Table(&user).Select("ID, Password").Where("Username = ? OR Email = ?", user.Username, user.Email).Get(&userFromDatabase)
+	_, err = s.URLShortenerDB.Table(&user).Select("ID, Username, Password").Where("Username = ? OR Email = ?", user.Username, user.Email).Get(&userFromDatabase)
 	if err != nil {
-		return "", "", "ERROR_FETCHING_USER", err
+		return TokenPair{}, "", "ERROR_FETCHING_USER", err
 	}
 
 	err = bcrypt.CompareHashAndPassword([]byte(userFromDatabase.Password), []byte(user.Password))
 	if err != nil {
-		return "", "", "WRONG_PASSWORD", err
+		return TokenPair{}, "", "WRONG_PASSWORD", err
 	}
 
+	// The username is in the payload of the token, also when the user logged in with their email
 	user.ID = userFromDatabase.ID
-	token, statusCode, err := GenerateSecurityToken(user)
+	user.Username = userFromDatabase.Username
+	tokenPair, statusCode, err := s.GenerateTokenPair(user, client)
 	if statusCode != "OK" || err != nil {
-		return "", "", statusCode, err
+		return TokenPair{}, "", statusCode, err
 	}
 
-	return token, user.ID, "OK", nil
+	return tokenPair, user.ID, "OK", nil
 }
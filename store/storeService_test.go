@@ -0,0 +1,845 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/devlaminckduncan/url-shortener/cache"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// newTestStore returns a storageService with a migrated in-memory SQLite database
+func newTestStore(t *testing.T) *storageService {
+	t.Helper()
+
+	return newTestStoreAt(t, ":memory:")
+}
+
+// newTestStoreAt returns a storageService with a migrated SQLite database at the path
+func newTestStoreAt(t *testing.T, path string) *storageService {
+	t.Helper()
+
+	enableLogger = false
+	os.Setenv("SECRET_JWT_KEY", "test")
+
+	storeDialect := sqliteDialect{path: path}
+	engine, _, err := storeDialect.openEngine()
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	t.Cleanup(func() {
+		engine.Close()
+	})
+
+	s := &storageService{
+		URLShortenerDB: engine,
+		dialect:        storeDialect,
+	}
+
+	err = s.migrateUp(0)
+	if err != nil {
+		t.Fatalf("Failed to migrate database: %v", err)
+	}
+
+	return s
+}
+
+// failOn makes every statement of the event ("INSERT", "UPDATE" or "DELETE") on the table fail, so the write after
+// the first one of a transaction can be broken
+func failOn(t *testing.T, s *storageService, event string, table string) {
+	t.Helper()
+
+	_, err := s.URLShortenerDB.Exec("CREATE TRIGGER fail_" + event + "_" + table + " BEFORE " + event + " ON " + table +
+		" BEGIN SELECT RAISE(ABORT, 'injected failure'); END")
+	if err != nil {
+		t.Fatalf("Failed to create trigger: %v", err)
+	}
+}
+
+// countRows returns the number of rows of every table that a failed write must leave alone
+func countRows(t *testing.T, s *storageService) map[string]int64 {
+	t.Helper()
+
+	tables := map[string]interface{}{
+		"User":                      &User{},
+		"UserToken":                 &UserToken{},
+		"RefreshToken":              &RefreshToken{},
+		"ShortenedURL":              &ShortenedURL{},
+		"UserShortenedURL":          &UserShortenedURL{},
+		"ShortenedURLVisitsHistory": &ShortenedURLVisitsHistory{},
+	}
+
+	counts := make(map[string]int64)
+	for name, bean := range tables {
+		count, err := s.URLShortenerDB.Count(bean)
+		if err != nil {
+			t.Fatalf("Failed to count %s rows: %v", name, err)
+		}
+		counts[name] = count
+	}
+
+	return counts
+}
+
+func assertRowsUnchanged(t *testing.T, before map[string]int64, after map[string]int64) {
+	t.Helper()
+
+	for table, count := range before {
+		if after[table] != count {
+			t.Errorf("%s has %d rows after the failed write, want %d", table, after[table], count)
+		}
+	}
+}
+
+// saveTestUser saves a user with a session and a ShortenedURL that was visited once
+func saveTestUser(t *testing.T, s *storageService, username string) (User, string, ShortenedURL) {
+	t.Helper()
+
+	user := User{FirstName: "Test", LastName: "User", Username: username, Email: username + "@example.com", Password: "password"}
+	tokenPair, userID, statusCode, err := s.SaveUser(user, SessionClient{})
+	if statusCode != "OK" || err != nil {
+		t.Fatalf("SaveUser returned %s: %v", statusCode, err)
+	}
+	user.ID = userID
+
+	shortenedURL, statusCode, err := s.SaveURL(ShortenedURL{Name: "Example", ShortURL: username + "Link", LongURL: "https://example.com"}, userID)
+	if statusCode != "OK" || err != nil {
+		t.Fatalf("SaveURL returned %s: %v", statusCode, err)
+	}
+
+	_, err = s.URLShortenerDB.Insert(&ShortenedURLVisitsHistory{ShortenedURLID: shortenedURL.ID})
+	if err != nil {
+		t.Fatalf("Failed to insert visit: %v", err)
+	}
+
+	return user, tokenPair.AccessToken, shortenedURL
+}
+
+func TestSaveURLRollsBackOnFailure(t *testing.T) {
+	s := newTestStore(t)
+	user, _, _ := saveTestUser(t, s, "saveurl")
+
+	failOn(t, s, "INSERT", "UserShortenedURL")
+	before := countRows(t, s)
+
+	_, statusCode, err := s.SaveURL(ShortenedURL{Name: "Broken", ShortURL: "broken", LongURL: "https://example.org"}, user.ID)
+	if statusCode != "ERROR_INSERTING_USERSHORTENEDURL" || err == nil {
+		t.Fatalf("SaveURL returned %s: %v, want ERROR_INSERTING_USERSHORTENEDURL", statusCode, err)
+	}
+
+	assertRowsUnchanged(t, before, countRows(t, s))
+}
+
+func TestSaveUserRollsBackOnFailure(t *testing.T) {
+	s := newTestStore(t)
+
+	failOn(t, s, "INSERT", "UserToken")
+	before := countRows(t, s)
+
+	user := User{FirstName: "Test", LastName: "User", Username: "saveuser", Email: "saveuser@example.com", Password: "password"}
+	_, _, statusCode, err := s.SaveUser(user, SessionClient{})
+	if statusCode != "ERROR_INSERTING_USERTOKEN" || err == nil {
+		t.Fatalf("SaveUser returned %s: %v, want ERROR_INSERTING_USERTOKEN", statusCode, err)
+	}
+
+	assertRowsUnchanged(t, before, countRows(t, s))
+}
+
+func TestDeleteShortenedURLRollsBackOnFailure(t *testing.T) {
+	s := newTestStore(t)
+	_, _, shortenedURL := saveTestUser(t, s, "deleteurl")
+
+	failOn(t, s, "DELETE", "UserShortenedURL")
+	before := countRows(t, s)
+
+	statusCode, err := s.DeleteShortenedURL(shortenedURL.ID)
+	if statusCode != "ERROR_DELETING_USERSHORTENEDURL" || err == nil {
+		t.Fatalf("DeleteShortenedURL returned %s: %v, want ERROR_DELETING_USERSHORTENEDURL", statusCode, err)
+	}
+
+	assertRowsUnchanged(t, before, countRows(t, s))
+}
+
+func TestDeleteUserRollsBackOnFailure(t *testing.T) {
+	s := newTestStore(t)
+	user, _, _ := saveTestUser(t, s, "deleteuser")
+
+	// The visits are deleted after the User, its tokens, the ShortenedURL and the UserShortenedURL
+	failOn(t, s, "DELETE", "ShortenedURLVisitsHistory")
+	before := countRows(t, s)
+
+	statusCode, err := s.DeleteUser(user.ID)
+	if statusCode != "ERROR_DELETING_SHORTENEDURLVISITSHISTORY" || err == nil {
+		t.Fatalf("DeleteUser returned %s: %v, want ERROR_DELETING_SHORTENEDURLVISITSHISTORY", statusCode, err)
+	}
+
+	assertRowsUnchanged(t, before, countRows(t, s))
+}
+
+func TestUpdateUserRollsBackOnFailure(t *testing.T) {
+	s := newTestStore(t)
+	user, token, _ := saveTestUser(t, s, "updateuser")
+
+	// Changing the username inserts a new token and then deletes the old one
+	failOn(t, s, "DELETE", "UserToken")
+	before := countRows(t, s)
+
+	user.Username = "renamed"
+	user.Password = ""
+	_, statusCode, err := s.UpdateUser(user, token)
+	if statusCode != "ERROR_DELETING_USERTOKEN" || err == nil {
+		t.Fatalf("UpdateUser returned %s: %v, want ERROR_DELETING_USERTOKEN", statusCode, err)
+	}
+
+	assertRowsUnchanged(t, before, countRows(t, s))
+
+	storedUser, statusCode, err := s.GetUser(user.ID)
+	if statusCode != "OK" || err != nil {
+		t.Fatalf("GetUser returned %s: %v", statusCode, err)
+	}
+	if storedUser.Username != "updateuser" {
+		t.Errorf("Username is %q after the failed update, want %q", storedUser.Username, "updateuser")
+	}
+}
+
+func TestNextShortURLCounterIncrements(t *testing.T) {
+	s := newTestStore(t)
+
+	for want := uint64(1); want <= 3; want++ {
+		value, statusCode, err := s.NextShortURLCounter()
+		if statusCode != "OK" || err != nil {
+			t.Fatalf("NextShortURLCounter returned %s: %v", statusCode, err)
+		}
+		if value != want {
+			t.Errorf("NextShortURLCounter = %d, want %d", value, want)
+		}
+	}
+}
+
+// withLocalTimezone makes the zone the local timezone until the test ends
+func withLocalTimezone(t *testing.T, zone *time.Location) {
+	local := time.Local
+	time.Local = zone
+	t.Cleanup(func() {
+		time.Local = local
+	})
+}
+
+func TestVisitStatsIgnoreTheLocalTimezone(t *testing.T) {
+	// SQLite stores the times in UTC, so a server in another timezone has to convert the range and the buckets
+	withLocalTimezone(t, time.FixedZone("JST", 9*60*60))
+
+	s := newTestStore(t)
+	_, _, shortenedURL := saveTestUser(t, s, "stats")
+	_, err := s.URLShortenerDB.Exec("DELETE FROM ShortenedURLVisitsHistory")
+	if err != nil {
+		t.Fatalf("Failed to delete visits: %v", err)
+	}
+
+	visitedAt := time.Date(2026, 10, 18, 11, 25, 0, 0, time.UTC)
+	_, err = s.URLShortenerDB.NoAutoTime().Insert(&ShortenedURLVisitsHistory{ShortenedURLID: shortenedURL.ID, VisitedAt: visitedAt})
+	if err != nil {
+		t.Fatalf("Failed to insert visit: %v", err)
+	}
+
+	tests := []struct {
+		from   time.Time
+		to     time.Time
+		visits int64
+	}{
+		{visitedAt.Add(-time.Hour), visitedAt.Add(time.Hour), 1},
+		{visitedAt.Add(time.Minute), visitedAt.Add(time.Hour), 0},
+		{visitedAt.Add(-time.Hour), visitedAt.Add(-time.Minute), 0},
+	}
+
+	for _, test := range tests {
+		stats, statusCode, err := s.GetShortenedURLVisitStats(shortenedURL.ID, test.from.In(time.Local), test.to.In(time.Local), "hour")
+		if statusCode != "OK" || err != nil {
+			t.Fatalf("GetShortenedURLVisitStats returned %s: %v", statusCode, err)
+		}
+		if stats.TotalVisits != test.visits {
+			t.Errorf("%d visits between %v and %v, want %d", stats.TotalVisits, test.from, test.to, test.visits)
+		}
+		if test.visits == 0 {
+			continue
+		}
+
+		if len(stats.Buckets) != 1 {
+			t.Fatalf("%d buckets, want 1", len(stats.Buckets))
+		}
+		if want := visitedAt.Truncate(time.Hour); !stats.Buckets[0].Start.Equal(want) {
+			t.Errorf("The bucket starts at %v, want %v", stats.Buckets[0].Start, want)
+		}
+	}
+}
+
+func TestArchiveExpiredShortenedURLsIgnoresTheLocalTimezone(t *testing.T) {
+	// A time with a positive offset sorts after the UTC times that SQLite stores when it's compared as text
+	withLocalTimezone(t, time.FixedZone("JST", 9*60*60))
+
+	s := newTestStore(t)
+	user, _, _ := saveTestUser(t, s, "archive")
+
+	expiresAt := time.Now().Add(time.Hour)
+	_, statusCode, err := s.SaveURL(ShortenedURL{Name: "Later", ShortURL: "later", LongURL: "https://example.org", ExpiresAt: &expiresAt}, user.ID)
+	if statusCode != "OK" || err != nil {
+		t.Fatalf("SaveURL returned %s: %v", statusCode, err)
+	}
+
+	archived, statusCode, err := s.ArchiveExpiredShortenedURLs()
+	if statusCode != "OK" || err != nil {
+		t.Fatalf("ArchiveExpiredShortenedURLs returned %s: %v", statusCode, err)
+	}
+	if archived != 0 {
+		t.Errorf("ArchiveExpiredShortenedURLs archived %d short URLs that expire in an hour", archived)
+	}
+}
+
+func TestGetUserSessionsIgnoresTheLocalTimezone(t *testing.T) {
+	// A time with a negative offset sorts before the UTC times that SQLite stores when it's compared as text, which
+	// would keep expired sessions
+	withLocalTimezone(t, time.FixedZone("PDT", -7*60*60))
+
+	s := newTestStore(t)
+	user, token, _ := saveTestUser(t, s, "sessions")
+
+	expiresAt := time.Now().Add(-time.Hour)
+	_, err := s.URLShortenerDB.Where("UserID = ?", user.ID).Update(&RefreshToken{ExpiresAt: expiresAt})
+	if err != nil {
+		t.Fatalf("Failed to expire the refresh token: %v", err)
+	}
+
+	sessions, statusCode, err := s.GetUserSessions(user.ID, token)
+	if statusCode != "OK" || err != nil {
+		t.Fatalf("GetUserSessions returned %s: %v", statusCode, err)
+	}
+	if len(sessions) != 0 {
+		t.Errorf("GetUserSessions returned %d sessions whose refresh token expired an hour ago", len(sessions))
+	}
+}
+
+// saveTestSession saves a user and returns its ID with the TokenPair of its session
+func saveTestSession(t *testing.T, s *storageService, username string) (string, TokenPair) {
+	t.Helper()
+
+	user := User{FirstName: "Test", LastName: "User", Username: username, Email: username + "@example.com", Password: "password"}
+	tokenPair, userID, statusCode, err := s.SaveUser(user, SessionClient{})
+	if statusCode != "OK" || err != nil {
+		t.Fatalf("SaveUser returned %s: %v", statusCode, err)
+	}
+
+	return userID, tokenPair
+}
+
+// assertAccessToken checks whether the access token can still be used
+func assertAccessToken(t *testing.T, s *storageService, accessToken string, want bool) {
+	t.Helper()
+
+	exists, _, err := s.CheckSecurityTokenExists(accessToken)
+	if err != nil {
+		t.Fatalf("CheckSecurityTokenExists returned %v", err)
+	}
+	if exists != want {
+		t.Errorf("The access token exists: %v, want %v", exists, want)
+	}
+}
+
+func TestRefreshTokenPairRotatesTheRefreshToken(t *testing.T) {
+	s := newTestStore(t)
+	_, login := saveTestSession(t, s, "rotated")
+
+	refreshed, statusCode, err := s.RefreshTokenPair(login.RefreshToken, SessionClient{})
+	if statusCode != "OK" || err != nil {
+		t.Fatalf("RefreshTokenPair returned %s: %v", statusCode, err)
+	}
+	if refreshed.RefreshToken == login.RefreshToken || refreshed.AccessToken == login.AccessToken {
+		t.Error("RefreshTokenPair returned the same tokens, want new ones")
+	}
+	assertAccessToken(t, s, login.AccessToken, false)
+	assertAccessToken(t, s, refreshed.AccessToken, true)
+
+	// The new refresh token can be rotated in turn
+	_, statusCode, err = s.RefreshTokenPair(refreshed.RefreshToken, SessionClient{})
+	if statusCode != "OK" || err != nil {
+		t.Errorf("RefreshTokenPair with the rotated refresh token returned %s: %v", statusCode, err)
+	}
+}
+
+func TestReusedRefreshTokenRevokesTheTokenFamily(t *testing.T) {
+	s := newTestStore(t)
+	userID, login := saveTestSession(t, s, "reused")
+	otherSession, _, statusCode, err := s.CheckLogin(User{Username: "reused", Password: "password"}, SessionClient{})
+	if statusCode != "OK" || err != nil {
+		t.Fatalf("CheckLogin returned %s: %v", statusCode, err)
+	}
+
+	refreshed, statusCode, err := s.RefreshTokenPair(login.RefreshToken, SessionClient{})
+	if statusCode != "OK" || err != nil {
+		t.Fatalf("RefreshTokenPair returned %s: %v", statusCode, err)
+	}
+
+	// Replaying the rotated refresh token means it was probably stolen, neither the thief nor the user can go on
+	_, statusCode, err = s.RefreshTokenPair(login.RefreshToken, SessionClient{})
+	if statusCode != "REUSED_REFRESHTOKEN" || err != nil {
+		t.Errorf("RefreshTokenPair with the rotated refresh token returned %s: %v, want REUSED_REFRESHTOKEN", statusCode, err)
+	}
+	_, statusCode, err = s.RefreshTokenPair(refreshed.RefreshToken, SessionClient{})
+	if statusCode != "REVOKED_REFRESHTOKEN" || err != nil {
+		t.Errorf("RefreshTokenPair with the latest refresh token returned %s: %v, want REVOKED_REFRESHTOKEN", statusCode, err)
+	}
+	assertAccessToken(t, s, refreshed.AccessToken, false)
+
+	// The other sessions of the user aren't affected
+	assertAccessToken(t, s, otherSession.AccessToken, true)
+	sessions, statusCode, err := s.GetUserSessions(userID, otherSession.AccessToken)
+	if statusCode != "OK" || err != nil || len(sessions) != 1 {
+		t.Errorf("GetUserSessions returned %d sessions, %s: %v, want only the other session", len(sessions), statusCode, err)
+	}
+}
+
+func TestExpiredRefreshTokenCantBeUsed(t *testing.T) {
+	s := newTestStore(t)
+	userID, login := saveTestSession(t, s, "expired")
+
+	_, err := s.URLShortenerDB.Where("UserID = ?", userID).Update(&RefreshToken{ExpiresAt: time.Now().Add(-time.Minute)})
+	if err != nil {
+		t.Fatalf("Failed to expire the refresh token: %v", err)
+	}
+
+	_, statusCode, err := s.RefreshTokenPair(login.RefreshToken, SessionClient{})
+	if statusCode != "EXPIRED_REFRESHTOKEN" || err != nil {
+		t.Errorf("RefreshTokenPair returned %s: %v, want EXPIRED_REFRESHTOKEN", statusCode, err)
+	}
+}
+
+func TestLogoutRevokesTheTokenFamily(t *testing.T) {
+	s := newTestStore(t)
+
+	// Logging out with either token of the session revokes both
+	for name, revoke := range map[string]func(s *storageService, tokenPair TokenPair) (string, error){
+		"RevokeRefreshToken": func(s *storageService, tokenPair TokenPair) (string, error) {
+			return s.RevokeRefreshToken(tokenPair.RefreshToken)
+		},
+		"RevokeSecurityToken": func(s *storageService, tokenPair TokenPair) (string, error) {
+			return s.RevokeSecurityToken(tokenPair.AccessToken)
+		},
+	} {
+		_, login := saveTestSession(t, s, name)
+
+		statusCode, err := revoke(s, login)
+		if statusCode != "OK" || err != nil {
+			t.Fatalf("%s returned %s: %v", name, statusCode, err)
+		}
+
+		assertAccessToken(t, s, login.AccessToken, false)
+		_, statusCode, err = s.RefreshTokenPair(login.RefreshToken, SessionClient{})
+		if statusCode != "REVOKED_REFRESHTOKEN" || err != nil {
+			t.Errorf("RefreshTokenPair after %s returned %s: %v, want REVOKED_REFRESHTOKEN", name, statusCode, err)
+		}
+	}
+}
+
+func TestGetLongURLLooksUpTheCandidatesInOrder(t *testing.T) {
+	for _, cached := range []bool{false, true} {
+		s := newTestStore(t)
+		if cached {
+			s.shortURLs = &shortURLCache{cache: cache.NewLRU(10), ttl: time.Minute, missingTTL: time.Minute}
+		}
+		user, _, _ := saveTestUser(t, s, "candidates")
+
+		for _, shortURL := range []string{"abcd2345", "MyLink", "EFGH2345", "efgh2345"} {
+			_, statusCode, err := s.SaveURL(ShortenedURL{Name: shortURL, ShortURL: shortURL, LongURL: "https://example.com/" + shortURL}, user.ID)
+			if statusCode != "OK" || err != nil {
+				t.Fatalf("SaveURL(%s) returned %s: %v", shortURL, statusCode, err)
+			}
+		}
+
+		tests := []struct {
+			shortURLs []string
+			want      string
+		}{
+			{[]string{"ABCD2345", "abcd2345"}, "abcd2345"},
+			{[]string{"MyLink"}, "MyLink"},
+			{[]string{"EFGH2345", "efgh2345"}, "EFGH2345"},
+			{[]string{"efgh2345"}, "efgh2345"},
+			{[]string{"IJKL2345", "ijkl2345"}, ""},
+		}
+
+		// The second round is served by the cache when there is one
+		for round := 0; round < 2; round++ {
+			for _, test := range tests {
+				shortenedURL, statusCode, err := s.GetLongURL(test.shortURLs, "", ShortenedURLVisitsHistory{})
+				if test.want == "" {
+					if statusCode != "NON_EXISTING_SHORTENEDURL" || err != nil {
+						t.Errorf("GetLongURL(%v) returned %s: %v, want NON_EXISTING_SHORTENEDURL", test.shortURLs, statusCode, err)
+					}
+					continue
+				}
+
+				if statusCode != "OK" || err != nil {
+					t.Fatalf("GetLongURL(%v) returned %s: %v", test.shortURLs, statusCode, err)
+				}
+				if want := "https://example.com/" + test.want; shortenedURL.LongURL != want {
+					t.Errorf("GetLongURL(%v) found %s, want %s (cached: %v)", test.shortURLs, shortenedURL.LongURL, want, cached)
+				}
+			}
+		}
+	}
+}
+
+// saveTestShortenedURL saves the ShortenedURL for the user
+func saveTestShortenedURL(t *testing.T, s *storageService, userID string, shortenedURL ShortenedURL) ShortenedURL {
+	t.Helper()
+
+	if shortenedURL.LongURL == "" {
+		shortenedURL.LongURL = "https://example.com/" + shortenedURL.ShortURL
+	}
+	shortenedURL.Name = shortenedURL.ShortURL
+
+	savedURL, statusCode, err := s.SaveURL(shortenedURL, userID)
+	if statusCode != "OK" || err != nil {
+		t.Fatalf("SaveURL(%s) returned %s: %v", shortenedURL.ShortURL, statusCode, err)
+	}
+
+	return savedURL
+}
+
+// startVisitRecorder records the visits that GetLongURL queues until the test ends
+func startVisitRecorder(t *testing.T, s *storageService) {
+	s.visits = newVisitRecorder(s)
+	t.Cleanup(func() {
+		s.visits.close()
+	})
+}
+
+// flushVisits saves the visits that were queued so far
+func flushVisits(s *storageService) {
+	s.visits.close()
+	s.visits = newVisitRecorder(s)
+}
+
+// countVisits saves the queued visits and returns how many visits the ShortenedURL has
+func countVisits(t *testing.T, s *storageService, id string) int64 {
+	t.Helper()
+
+	flushVisits(s)
+
+	visits, err := s.URLShortenerDB.Where("ShortenedURLID = ?", id).Count(&ShortenedURLVisitsHistory{})
+	if err != nil {
+		t.Fatalf("Failed to count visits: %v", err)
+	}
+
+	return visits
+}
+
+func TestGetLongURLOfExpiredShortenedURL(t *testing.T) {
+	s := newTestStore(t)
+	startVisitRecorder(t, s)
+	user, _, _ := saveTestUser(t, s, "expired")
+
+	expiresAt := time.Now().Add(-time.Minute)
+	expired := saveTestShortenedURL(t, s, user.ID, ShortenedURL{ShortURL: "expired", ExpiresAt: &expiresAt})
+
+	_, statusCode, err := s.GetLongURL([]string{"expired"}, "", ShortenedURLVisitsHistory{})
+	if statusCode != "EXPIRED_SHORTENEDURL" || err != nil {
+		t.Errorf("GetLongURL returned %s: %v, want EXPIRED_SHORTENEDURL", statusCode, err)
+	}
+	if visits := countVisits(t, s, expired.ID); visits != 0 {
+		t.Errorf("The expired short URL has %d visits, want 0", visits)
+	}
+}
+
+func TestArchiveExpiredShortenedURLs(t *testing.T) {
+	s := newTestStore(t)
+	user, _, _ := saveTestUser(t, s, "sweeper")
+
+	expiredAt := time.Now().Add(-time.Minute)
+	expiresAt := time.Now().Add(time.Hour)
+	expired := saveTestShortenedURL(t, s, user.ID, ShortenedURL{ShortURL: "expired", ExpiresAt: &expiredAt})
+	later := saveTestShortenedURL(t, s, user.ID, ShortenedURL{ShortURL: "later", ExpiresAt: &expiresAt})
+
+	for run, want := range []int64{1, 0} {
+		archived, statusCode, err := s.ArchiveExpiredShortenedURLs()
+		if statusCode != "OK" || err != nil {
+			t.Fatalf("ArchiveExpiredShortenedURLs returned %s: %v", statusCode, err)
+		}
+		if archived != want {
+			t.Errorf("Run %d archived %d short URLs, want %d", run+1, archived, want)
+		}
+	}
+
+	for _, test := range []struct {
+		shortenedURL ShortenedURL
+		archived     bool
+	}{
+		{expired, true},
+		{later, false},
+	} {
+		var stored ShortenedURL
+		_, err := s.URLShortenerDB.ID(test.shortenedURL.ID).Get(&stored)
+		if err != nil {
+			t.Fatalf("Failed to fetch %s: %v", test.shortenedURL.ShortURL, err)
+		}
+		if (stored.ArchivedAt != nil) != test.archived {
+			t.Errorf("%s has ArchivedAt %v, want archived %v", stored.ShortURL, stored.ArchivedAt, test.archived)
+		}
+	}
+}
+
+func TestGetLongURLOfUnknownShortURLSavesNoVisit(t *testing.T) {
+	s := newTestStore(t)
+	startVisitRecorder(t, s)
+	_, _, shortenedURL := saveTestUser(t, s, "unknown")
+	before := countRows(t, s)["ShortenedURLVisitsHistory"]
+
+	_, statusCode, err := s.GetLongURL([]string{"doesNotExist"}, "", ShortenedURLVisitsHistory{})
+	if statusCode != "NON_EXISTING_SHORTENEDURL" || err != nil {
+		t.Errorf("GetLongURL returned %s: %v, want NON_EXISTING_SHORTENEDURL", statusCode, err)
+	}
+	flushVisits(s)
+	if after := countRows(t, s)["ShortenedURLVisitsHistory"]; after != before {
+		t.Errorf("%d visits were saved for an unknown short URL", after-before)
+	}
+
+	// A known short URL does save its visit
+	_, statusCode, err = s.GetLongURL([]string{shortenedURL.ShortURL}, "", ShortenedURLVisitsHistory{})
+	if statusCode != "OK" || err != nil {
+		t.Fatalf("GetLongURL returned %s: %v", statusCode, err)
+	}
+	flushVisits(s)
+	if after := countRows(t, s)["ShortenedURLVisitsHistory"]; after != before+1 {
+		t.Errorf("%d visits were saved for a known short URL, want 1", after-before)
+	}
+}
+
+func TestGetLongURLDoesNotGoOverMaxVisits(t *testing.T) {
+	// Every visitor gets its own connection, so they can read the ShortenedURL before the others counted their visit
+	s := newTestStoreAt(t, filepath.Join(t.TempDir(), "test.db")+"?_busy_timeout=10000")
+	s.URLShortenerDB.SetMaxOpenConns(20)
+	user, _, _ := saveTestUser(t, s, "limited")
+	limited := saveTestShortenedURL(t, s, user.ID, ShortenedURL{ShortURL: "limited", MaxVisits: 3})
+
+	start := make(chan struct{})
+	statusCodes := make(chan string, 20)
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			_, statusCode, _ := s.GetLongURL([]string{"limited"}, "", ShortenedURLVisitsHistory{})
+			statusCodes <- statusCode
+		}()
+	}
+	close(start)
+	wg.Wait()
+	close(statusCodes)
+
+	counts := make(map[string]int)
+	for statusCode := range statusCodes {
+		counts[statusCode]++
+	}
+	if counts["OK"] != 3 || counts["EXHAUSTED_SHORTENEDURL"] != 17 {
+		t.Errorf("20 concurrent visits returned %v, want 3 OK and 17 EXHAUSTED_SHORTENEDURL", counts)
+	}
+
+	var stored ShortenedURL
+	_, err := s.URLShortenerDB.ID(limited.ID).Get(&stored)
+	if err != nil {
+		t.Fatalf("Failed to fetch the ShortenedURL: %v", err)
+	}
+	if stored.Visits != 3 {
+		t.Errorf("Visits is %d, want 3", stored.Visits)
+	}
+}
+
+func TestOneTimeShortURLRedirectsOnce(t *testing.T) {
+	s := newTestStore(t)
+	startVisitRecorder(t, s)
+	user, _, _ := saveTestUser(t, s, "onetime")
+	oneTime := saveTestShortenedURL(t, s, user.ID, ShortenedURL{ShortURL: "onetime", MaxVisits: 1})
+
+	for visit, want := range []string{"OK", "EXHAUSTED_SHORTENEDURL", "EXHAUSTED_SHORTENEDURL"} {
+		_, statusCode, err := s.GetLongURL([]string{"onetime"}, "", ShortenedURLVisitsHistory{})
+		if statusCode != want || err != nil {
+			t.Errorf("Visit %d returned %s: %v, want %s", visit+1, statusCode, err, want)
+		}
+	}
+
+	// The visit was already counted, the recorder mustn't count it again
+	if visits := countVisits(t, s, oneTime.ID); visits != 1 {
+		t.Errorf("The one-time short URL has %d visits in its history, want 1", visits)
+	}
+	var stored ShortenedURL
+	_, err := s.URLShortenerDB.ID(oneTime.ID).Get(&stored)
+	if err != nil {
+		t.Fatalf("Failed to fetch the ShortenedURL: %v", err)
+	}
+	if stored.Visits != 1 {
+		t.Errorf("Visits is %d, want 1", stored.Visits)
+	}
+}
+
+func TestGetLongURLOfPasswordProtectedShortURL(t *testing.T) {
+	s := newTestStore(t)
+	user, _, _ := saveTestUser(t, s, "protected")
+	saveTestShortenedURL(t, s, user.ID, ShortenedURL{ShortURL: "protected", Password: "secret"})
+
+	for _, test := range []struct {
+		password   string
+		statusCode string
+	}{
+		{"", "PASSWORD_REQUIRED"},
+		{"wrong", "WRONG_PASSWORD"},
+		{"secret", "OK"},
+	} {
+		_, statusCode, err := s.GetLongURL([]string{"protected"}, test.password, ShortenedURLVisitsHistory{})
+		if statusCode != test.statusCode || err != nil {
+			t.Errorf("GetLongURL with password %q returned %s: %v, want %s", test.password, statusCode, err, test.statusCode)
+		}
+	}
+
+	var stored ShortenedURL
+	_, err := s.URLShortenerDB.Where("ShortURL = ?", "protected").Get(&stored)
+	if err != nil {
+		t.Fatalf("Failed to fetch the ShortenedURL: %v", err)
+	}
+	if cost, err := bcrypt.Cost([]byte(stored.Password)); cost != shortURLPasswordCost || err != nil {
+		t.Errorf("The password is hashed with cost %d: %v, want %d", cost, err, shortURLPasswordCost)
+	}
+}
+
+func TestGetLongURLOfScheduledShortenedURL(t *testing.T) {
+	s := newTestStore(t)
+	user, _, _ := saveTestUser(t, s, "scheduled")
+	now := time.Now()
+	later, earlier := now.Add(time.Hour), now.Add(-time.Hour)
+	saveTestShortenedURL(t, s, user.ID, ShortenedURL{ShortURL: "upcoming", ActiveFrom: &later, Password: "secret"})
+	saveTestShortenedURL(t, s, user.ID, ShortenedURL{ShortURL: "ended", ActiveUntil: &earlier})
+	saveTestShortenedURL(t, s, user.ID, ShortenedURL{ShortURL: "started", ActiveFrom: &earlier})
+
+	for _, test := range []struct {
+		shortURL   string
+		password   string
+		statusCode string
+	}{
+		// Visitors who don't know the password can't see the activation window
+		{"upcoming", "", "PASSWORD_REQUIRED"},
+		{"upcoming", "wrong", "WRONG_PASSWORD"},
+		{"upcoming", "secret", "NOT_YET_ACTIVE_SHORTENEDURL"},
+		{"ended", "", "NO_LONGER_ACTIVE_SHORTENEDURL"},
+		{"started", "", "OK"},
+	} {
+		_, statusCode, err := s.GetLongURL([]string{test.shortURL}, test.password, ShortenedURLVisitsHistory{})
+		if statusCode != test.statusCode || err != nil {
+			t.Errorf("GetLongURL(%s) with password %q returned %s: %v, want %s", test.shortURL, test.password, statusCode,
+				err, test.statusCode)
+		}
+	}
+}
+
+func TestUpdateShortenedURLChecksTheScheduleAgainstTheStoredValues(t *testing.T) {
+	s := newTestStore(t)
+	user, _, _ := saveTestUser(t, s, "rescheduled")
+	activeUntil := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	shortenedURL := saveTestShortenedURL(t, s, user.ID, ShortenedURL{ShortURL: "rescheduled", ActiveUntil: &activeUntil})
+
+	afterTheEnd := activeUntil.Add(time.Hour)
+	statusCode, err := s.UpdateShortenedURL(ShortenedURL{ID: shortenedURL.ID, ActiveFrom: &afterTheEnd}, user.ID)
+	if statusCode != "INVALID_SCHEDULE" || err != nil {
+		t.Errorf("UpdateShortenedURL with an activeFrom after the stored activeUntil returned %s: %v, want INVALID_SCHEDULE",
+			statusCode, err)
+	}
+
+	beforeTheEnd := activeUntil.Add(-time.Minute)
+	statusCode, err = s.UpdateShortenedURL(ShortenedURL{ID: shortenedURL.ID, ActiveFrom: &beforeTheEnd}, user.ID)
+	if statusCode != "OK" || err != nil {
+		t.Fatalf("UpdateShortenedURL with an activeFrom before the stored activeUntil returned %s: %v", statusCode, err)
+	}
+
+	var stored ShortenedURL
+	_, err = s.URLShortenerDB.ID(shortenedURL.ID).Get(&stored)
+	if err != nil {
+		t.Fatalf("Failed to fetch the ShortenedURL: %v", err)
+	}
+	if stored.ActiveFrom == nil || !stored.ActiveFrom.Equal(beforeTheEnd) {
+		t.Errorf("ActiveFrom is %v, want %v", stored.ActiveFrom, beforeTheEnd)
+	}
+}
+
+func TestUpdateShortenedURLRecordsRevisionsAndInvalidatesTheCache(t *testing.T) {
+	s := newTestStore(t)
+	s.shortURLs = &shortURLCache{cache: cache.NewLRU(10), ttl: time.Minute, missingTTL: time.Minute}
+	user, _, _ := saveTestUser(t, s, "revised")
+	shortenedURL := saveTestShortenedURL(t, s, user.ID, ShortenedURL{ShortURL: "revised"})
+
+	// Caches the short URL
+	_, statusCode, err := s.GetLongURL([]string{"revised"}, "", ShortenedURLVisitsHistory{})
+	if statusCode != "OK" || err != nil {
+		t.Fatalf("GetLongURL returned %s: %v", statusCode, err)
+	}
+
+	for _, longURL := range []string{"https://example.org/new", "https://example.org/new"} {
+		statusCode, err = s.UpdateShortenedURL(ShortenedURL{ID: shortenedURL.ID, LongURL: longURL}, user.ID)
+		if statusCode != "OK" || err != nil {
+			t.Fatalf("UpdateShortenedURL returned %s: %v", statusCode, err)
+		}
+	}
+
+	// Updating to the same long URL again isn't a revision
+	var revisions []ShortenedURLRevision
+	err = s.URLShortenerDB.Where("ShortenedURLID = ?", shortenedURL.ID).Find(&revisions)
+	if err != nil {
+		t.Fatalf("Failed to fetch the ShortenedURLRevisions: %v", err)
+	}
+	want := ShortenedURLRevision{ShortenedURLID: shortenedURL.ID, PreviousLongURL: "https://example.com/revised",
+		NewLongURL: "https://example.org/new", ChangedBy: user.ID}
+	if len(revisions) != 1 {
+		t.Fatalf("%d ShortenedURLRevisions were recorded, want 1", len(revisions))
+	}
+	revision := revisions[0]
+	if revision.ID == "" || revision.ChangedAt.IsZero() {
+		t.Errorf("The ShortenedURLRevision has no ID or ChangedAt: %+v", revision)
+	}
+	revision.ID, revision.ChangedAt = "", time.Time{}
+	if revision != want {
+		t.Errorf("The ShortenedURLRevision is %+v, want %+v", revision, want)
+	}
+
+	found, statusCode, err := s.GetLongURL([]string{"revised"}, "", ShortenedURLVisitsHistory{})
+	if statusCode != "OK" || err != nil || found.LongURL != "https://example.org/new" {
+		t.Errorf("GetLongURL returned %s %s: %v after the update, want the new long URL", found.LongURL, statusCode, err)
+	}
+}
+
+func TestGetUserShortenedURLsListsOnlyTheVisitTimes(t *testing.T) {
+	s := newTestStore(t)
+	startVisitRecorder(t, s)
+	user, _, shortenedURL := saveTestUser(t, s, "visited")
+
+	for i := 0; i < 2; i++ {
+		_, statusCode, err := s.GetLongURL([]string{shortenedURL.ShortURL}, "", ShortenedURLVisitsHistory{UserAgent: "Mozilla/5.0", IPHash: "abcdef"})
+		if statusCode != "OK" || err != nil {
+			t.Fatalf("GetLongURL returned %s: %v", statusCode, err)
+		}
+	}
+	flushVisits(s)
+
+	urls, statusCode, err := s.GetUserShortenedURLs(user.ID)
+	if statusCode != "OK" || err != nil || len(urls) != 1 {
+		t.Fatalf("GetUserShortenedURLs returned %d short URLs, %s: %v, want 1", len(urls), statusCode, err)
+	}
+	// saveTestUser saved a visit as well
+	if len(urls[0].Analytics) != 3 {
+		t.Fatalf("The analytics are %v, want the times of the 3 visits", urls[0].Analytics)
+	}
+	for _, visitedAt := range urls[0].Analytics {
+		if visitedAt == "" || strings.Contains(visitedAt, "Mozilla") || strings.Contains(visitedAt, "abcdef") {
+			t.Errorf("The analytics contain %q, want only a visit time", visitedAt)
+		}
+	}
+}
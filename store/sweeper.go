@@ -0,0 +1,41 @@
+package store
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+const defaultSweepInterval = time.Hour
+
+// StartExpiredLinksSweeper archives the expired ShortenedURLs in the background, the EXPIRED_LINKS_SWEEP_INTERVAL
+// environment variable sets how often it runs and "0" disables it
+func StartExpiredLinksSweeper(urlStore ShortenedURLStore) {
+	interval := defaultSweepInterval
+	if value := os.Getenv("EXPIRED_LINKS_SWEEP_INTERVAL"); value != "" {
+		var err error
+		interval, err = time.ParseDuration(value)
+		if err != nil || interval < 0 {
+			panic(fmt.Sprintf("Invalid EXPIRED_LINKS_SWEEP_INTERVAL %q, use a duration like 10m or 1h", value))
+		}
+	}
+	if interval == 0 {
+		return
+	}
+
+	go sweepExpiredShortenedURLs(urlStore, interval)
+}
+
+// sweepExpiredShortenedURLs archives the expired ShortenedURLs every interval
+func sweepExpiredShortenedURLs(urlStore ShortenedURLStore, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		// Failures are already logged by the store and the next tick simply tries again
+		archived, _, _ := urlStore.ArchiveExpiredShortenedURLs()
+		if archived > 0 {
+			fmt.Printf("Archived %d expired short URL(s)\n", archived)
+		}
+	}
+}
@@ -0,0 +1,7 @@
+package store
+
+// TokenPair is a short-lived access token (a JWT) with the refresh token that gets the next TokenPair when it expires
+type TokenPair struct {
+	AccessToken  string
+	RefreshToken string
+}
@@ -0,0 +1,34 @@
+package store
+
+import "xorm.io/xorm"
+
+// transaction runs f in a database transaction that is only committed when f returns the "OK" status code without an error.
+// f must use the given session for all of its queries because SQLite uses a single connection.
+func (s *storageService) transaction(f func(session *xorm.Session) (string, error)) (string, error) {
+	session := s.URLShortenerDB.NewSession()
+	defer session.Close()
+
+	err := session.Begin()
+	if err != nil {
+		s.logError("Failed to begin transaction:\n" + err.Error())
+		return "ERROR_BEGINNING_TRANSACTION", err
+	}
+
+	statusCode, err := f(session)
+	if statusCode != "OK" || err != nil {
+		rollbackErr := session.Rollback()
+		if rollbackErr != nil {
+			s.logError("Failed to roll back transaction:\n" + rollbackErr.Error())
+		}
+
+		return statusCode, err
+	}
+
+	err = session.Commit()
+	if err != nil {
+		s.logError("Failed to commit transaction:\n" + err.Error())
+		return "ERROR_COMMITTING_TRANSACTION", err
+	}
+
+	return "OK", nil
+}
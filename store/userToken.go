@@ -1,7 +1,16 @@
 package store
 
-// UserToken contains the security tokens associated with a user
+import "time"
+
+// UserToken contains the security tokens associated with a user with the details of the session they belong to
 type UserToken struct {
 	UserID string `xorm:"not null"`
 	Token  []byte `xorm:"not null"`
+	// FamilyID is shared by the access tokens and RefreshTokens of one login
+	FamilyID string `xorm:"null"`
+	// CreatedAt is when the user logged in, refreshed tokens keep it
+	CreatedAt  time.Time  `xorm:"null"`
+	LastUsedAt *time.Time `xorm:"null"`
+	UserAgent  string     `xorm:"text null"`
+	IP         string     `xorm:"null"`
 }
@@ -0,0 +1,168 @@
+package store
+
+import (
+	"expvar"
+	"sync"
+	"time"
+
+	"xorm.io/xorm"
+)
+
+const (
+	visitQueueSize     = 10000
+	visitBatchSize     = 100
+	visitFlushInterval = time.Second
+)
+
+// The visit counters are published on /debug/vars when ENABLE_METRICS is true
+var (
+	visitsQueued     = expvar.NewInt("visitsQueued")
+	visitsDropped    = expvar.NewInt("visitsDropped")
+	visitsRecorded   = expvar.NewInt("visitsRecorded")
+	visitFlushErrors = expvar.NewInt("visitFlushErrors")
+)
+
+// visitRecorder saves the visits in batches in the background so redirects don't wait on the database, when the queue
+// is full the visits are dropped instead of slowing the redirects down
+type visitRecorder struct {
+	s     *storageService
+	queue chan queuedVisit
+	done  chan struct{}
+
+	// mutex keeps record from sending on the queue while close closes it
+	mutex  sync.RWMutex
+	closed bool
+}
+
+type queuedVisit struct {
+	visit ShortenedURLVisitsHistory
+	// counted is true when the Visits column of the ShortenedURL was already incremented to enforce MaxVisits
+	counted bool
+}
+
+// newVisitRecorder starts a visitRecorder that saves its visits with the given storageService
+func newVisitRecorder(s *storageService) *visitRecorder {
+	r := &visitRecorder{
+		s:     s,
+		queue: make(chan queuedVisit, visitQueueSize),
+		done:  make(chan struct{}),
+	}
+
+	if expvar.Get("visitQueueLength") == nil {
+		expvar.Publish("visitQueueLength", expvar.Func(func() interface{} {
+			return len(r.queue)
+		}))
+	}
+
+	go r.run()
+
+	return r
+}
+
+// record queues the visit without blocking, the visit is dropped when the visitRecorder was closed. A nil visitRecorder
+// records nothing.
+func (r *visitRecorder) record(visit ShortenedURLVisitsHistory, counted bool) {
+	if r == nil {
+		return
+	}
+
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	if r.closed {
+		visitsDropped.Add(1)
+		return
+	}
+
+	select {
+	case r.queue <- queuedVisit{visit: visit, counted: counted}:
+		visitsQueued.Add(1)
+	default:
+		visitsDropped.Add(1)
+	}
+}
+
+// close saves the queued visits and stops the visitRecorder, the visits recorded afterwards are dropped
+func (r *visitRecorder) close() {
+	if r == nil {
+		return
+	}
+
+	r.mutex.Lock()
+	if !r.closed {
+		r.closed = true
+		close(r.queue)
+	}
+	r.mutex.Unlock()
+
+	<-r.done
+}
+
+func (r *visitRecorder) run() {
+	defer close(r.done)
+
+	ticker := time.NewTicker(visitFlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]queuedVisit, 0, visitBatchSize)
+	for {
+		select {
+		case queued, ok := <-r.queue:
+			if !ok {
+				r.flush(batch)
+				return
+			}
+
+			batch = append(batch, queued)
+			if len(batch) == visitBatchSize {
+				r.flush(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			r.flush(batch)
+			batch = batch[:0]
+		}
+	}
+}
+
+// flush saves the batch of visits and increments the Visits column of the ShortenedURLs in a single transaction
+func (r *visitRecorder) flush(batch []queuedVisit) {
+	if len(batch) == 0 {
+		return
+	}
+
+	visits := make([]ShortenedURLVisitsHistory, 0, len(batch))
+	uncountedVisits := make(map[string]int)
+	for _, queued := range batch {
+		visits = append(visits, queued.visit)
+		if !queued.counted {
+			uncountedVisits[queued.visit.ShortenedURLID]++
+		}
+	}
+
+	statusCode, err := r.s.transaction(func(session *xorm.Session) (string, error) {
+		// The visits were timestamped when they were queued
+		_, err := session.NoAutoTime().Insert(&visits)
+		if err != nil {
+			r.s.logError("Failed to insert data into table ShortenedURLVisitsHistory:\n" + err.Error())
+			return "ERROR_INSERTING_VISIT", err
+		}
+
+		for id, count := range uncountedVisits {
+			_, err = session.Exec("UPDATE ShortenedURL SET Visits = Visits + ? WHERE ID = ?", count, id)
+			if err != nil {
+				r.s.logError("Failed to update data in table ShortenedURL:\n" + err.Error())
+				return "ERROR_UPDATING_SHORTENEDURL", err
+			}
+		}
+
+		return "OK", nil
+	})
+	if statusCode != "OK" || err != nil {
+		visitFlushErrors.Add(1)
+		visitsDropped.Add(int64(len(batch)))
+		return
+	}
+
+	visitsRecorded.Add(int64(len(batch)))
+}
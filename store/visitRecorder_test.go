@@ -0,0 +1,50 @@
+package store
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestVisitRecorderDropsVisitsAfterClose(t *testing.T) {
+	s := newTestStore(t)
+	_, _, shortenedURL := saveTestUser(t, s, "recorder")
+	recorder := newVisitRecorder(s)
+
+	recorder.record(ShortenedURLVisitsHistory{ShortenedURLID: shortenedURL.ID}, false)
+
+	// The handlers that are still running when the shutdown times out keep recording while the store closes
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				recorder.record(ShortenedURLVisitsHistory{ShortenedURLID: shortenedURL.ID}, false)
+			}
+		}()
+	}
+
+	recorder.close()
+	wg.Wait()
+	recorder.record(ShortenedURLVisitsHistory{ShortenedURLID: shortenedURL.ID}, false)
+	recorder.close()
+
+	// saveTestUser already inserted a visit
+	visits, err := s.URLShortenerDB.Count(&ShortenedURLVisitsHistory{})
+	if err != nil {
+		t.Fatalf("Failed to count visits: %v", err)
+	}
+	if visits < 2 {
+		t.Errorf("%d visits were saved, want the one recorded before close", visits-1)
+	}
+}
+
+func TestGetLongURLWithoutVisitRecorder(t *testing.T) {
+	s := newTestStore(t)
+	_, _, shortenedURL := saveTestUser(t, s, "norecorder")
+
+	_, statusCode, err := s.GetLongURL([]string{shortenedURL.ShortURL}, "", ShortenedURLVisitsHistory{})
+	if statusCode != "OK" || err != nil {
+		t.Errorf("GetLongURL returned %s: %v", statusCode, err)
+	}
+}
@@ -0,0 +1,29 @@
+package store
+
+import "time"
+
+// VisitStats contains the visits of a ShortenedURL between From and To, counted per hour, day or week
+type VisitStats struct {
+	From           time.Time          `json:"from"`
+	To             time.Time          `json:"to"`
+	Interval       string             `json:"interval"`
+	TotalVisits    int64              `json:"totalVisits"`
+	UniqueVisitors int64              `json:"uniqueVisitors"`
+	Buckets        []VisitStatsBucket `json:"buckets"`
+}
+
+// VisitStatsBucket contains the visits of one hour, day or week, unique visitors are estimated with the IP hashes
+type VisitStatsBucket struct {
+	Start          time.Time `json:"start"`
+	Visits         int64     `json:"visits"`
+	UniqueVisitors int64     `json:"uniqueVisitors"`
+}
+
+// visitStatsRow is a row of the aggregation query, the database formats Bucket as "YYYY-MM-DD HH:MM:SS"
+type visitStatsRow struct {
+	Bucket         string
+	Visits         int64
+	UniqueVisitors int64
+}
+
+const visitStatsBucketLayout = "2006-01-02 15:04:05"